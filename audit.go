@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// Number of peers closest to a key the auditor asks for the parcel.
+const auditHolderCount = 3
+
+// blockSeeded remembers when the builder started seeding a block, so the
+// auditor can report durability against block age.
+func (s *Service) blockSeeded(blockID int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.seededBlocks == nil {
+		s.seededBlocks = make(map[int]time.Time)
+	}
+	s.seededBlocks[blockID] = time.Now()
+}
+
+// recentBlocks returns the window most recently seeded blocks.
+func (s *Service) recentBlocks(window int) map[int]time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	latest := -1
+	for blockID := range s.seededBlocks {
+		if blockID > latest {
+			latest = blockID
+		}
+	}
+
+	blocks := make(map[int]time.Time)
+	for blockID, seededAt := range s.seededBlocks {
+		if blockID > latest-window {
+			blocks[blockID] = seededAt
+		}
+	}
+	return blocks
+}
+
+// StartAudit runs on the builder: every interval it picks sampleCount random
+// parcels of each of the window most recent blocks, asks the peers closest to
+// each key whether they still hold it, and re-puts the parcels nobody holds.
+// One durability row is recorded per block and audit round.
+func (s *Service) StartAudit(ctx context.Context, store SampleStore, dht *dht.IpfsDHT, blockDimension int, parcelSize int, interval int, sampleCount int, window int, stats *Stats) {
+	auditTicker := time.NewTicker(time.Duration(interval) * time.Second)
+	defer auditTicker.Stop()
+
+	allParcels := SplitSamplesIntoParcels(blockDimension, parcelSize, "all")
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-auditTicker.C:
+		}
+
+		for blockID, seededAt := range s.recentBlocks(window) {
+			parcels := make([]Parcel, len(allParcels))
+			copy(parcels, allParcels)
+			rand.Shuffle(len(parcels), func(i, j int) {
+				parcels[i], parcels[j] = parcels[j], parcels[i]
+			})
+			if len(parcels) > sampleCount {
+				parcels = parcels[:sampleCount]
+			}
+
+			s.auditBlock(ctx, store, dht, blockID, seededAt, parcels, stats)
+		}
+	}
+}
+
+func (s *Service) auditBlock(ctx context.Context, store SampleStore, dht *dht.IpfsDHT, blockID int, seededAt time.Time, parcels []Parcel, stats *Stats) {
+	auditTime := time.Now()
+
+	retrievable := 0
+	holders := 0
+	repaired := 0
+	var countMu sync.Mutex
+	var parcelWg sync.WaitGroup
+	for _, parcel := range parcels {
+		parcelWg.Add(1)
+		go func(p Parcel) {
+			defer parcelWg.Done()
+
+			ref := NewParcelRef(blockID, p)
+			parcelHolders, parcelRetrievable := s.auditParcel(ctx, store, dht, ref)
+
+			// The store is not seeded in subnet mode, so there is nothing to
+			// repair into.
+			repairErr := errParcelNotFound
+			if !parcelRetrievable && s.subnets == nil {
+				repairErr = store.Put(ctx, ref, make([]byte, p.SampleCount*512))
+				if repairErr != nil {
+					log.Printf("[B - %s] Failed to repair parcel %s: %s\n", s.host.ID()[0:5], ref.Key(), repairErr.Error())
+				}
+			}
+
+			countMu.Lock()
+			defer countMu.Unlock()
+
+			holders += parcelHolders
+			if parcelRetrievable {
+				retrievable++
+			} else if repairErr == nil {
+				repaired++
+			}
+		}(parcel)
+	}
+	parcelWg.Wait()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats.DurabilityTimestamps = append(stats.DurabilityTimestamps, auditTime)
+	stats.DurabilityBlockIDs = append(stats.DurabilityBlockIDs, blockID)
+	stats.DurabilityBlockAges = append(stats.DurabilityBlockAges, auditTime.Sub(seededAt))
+	stats.DurabilityAuditedParcels = append(stats.DurabilityAuditedParcels, len(parcels))
+	stats.DurabilityRetrievableParcels = append(stats.DurabilityRetrievableParcels, retrievable)
+	stats.DurabilityHolders = append(stats.DurabilityHolders, holders)
+	stats.DurabilityRepairedParcels = append(stats.DurabilityRepairedParcels, repaired)
+
+	log.Printf("[B - %s] Audit of block %d: %d/%d parcels retrievable, %d repaired.\n", s.host.ID()[0:5], blockID, retrievable, len(parcels), repaired)
+}
+
+// auditParcel returns how many holders serve the parcel and whether it is
+// still retrievable, not counting this node's own copy. With the DHT store the
+// holders are the peers closest to the key. The push and provider stores can
+// serve a parcel from peers countHolders does not ask, so their own Get
+// decides when no holder answered. In subnet mode the parcel's subnet does.
+func (s *Service) auditParcel(ctx context.Context, store SampleStore, dht *dht.IpfsDHT, ref ParcelRef) (int, bool) {
+	if s.subnets != nil {
+		_, err := s.subnets.Sample(ctx, s, ref)
+		return 0, err == nil
+	}
+
+	holders := s.countHolders(ctx, dht, ref)
+	if holders > 0 || config.StoreType == "dht" {
+		return holders, holders > 0
+	}
+
+	_, err := store.Get(ctx, ref)
+	return holders, err == nil
+}
+
+// countHolders returns how many of the peers closest to the parcel's key (or,
+// with the provider store, of its providers), other than this node, serve it
+// over the sample protocol. The builder's own copy is left out so the audit
+// measures what the network still holds.
+func (s *Service) countHolders(ctx context.Context, dht *dht.IpfsDHT, ref ParcelRef) int {
+	lookupCtx, cancel := context.WithTimeout(ctx, sampleRequestTimeout)
+	var closestPeers []peer.ID
+	if config.StoreType == "provider" {
+		for provider := range dht.FindProvidersAsync(lookupCtx, parcelCid(ref.Key()), auditHolderCount+1) {
+			s.host.Peerstore().AddAddrs(provider.ID, provider.Addrs, sampleRequestTimeout)
+			closestPeers = append(closestPeers, provider.ID)
+		}
+	} else {
+		closestPeers, _ = dht.GetClosestPeers(lookupCtx, ref.Key())
+	}
+	cancel()
+
+	closestPeers = FilterSelf(closestPeers, s.host.ID())
+	if len(closestPeers) > auditHolderCount {
+		closestPeers = closestPeers[:auditHolderCount]
+	}
+
+	holders := 0
+	for _, p := range closestPeers {
+		requestCtx, cancel := context.WithTimeout(ctx, sampleRequestTimeout)
+		parcels, err := s.RequestParcels(requestCtx, p, []ParcelRef{ref})
+		cancel()
+
+		if err == nil && len(parcels) == 1 && parcels[0].Found {
+			holders++
+		}
+	}
+	return holders
+}
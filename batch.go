@@ -0,0 +1,274 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	kb "github.com/libp2p/go-libp2p-kbucket"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// Number of closest peers of a key that are tried, one round each, before a
+// parcel falls back to the sample store.
+const batchCandidateCount = 3
+
+type batchedParcel struct {
+	ref        ParcelRef
+	candidates []peer.ID
+	found      bool
+}
+
+// BatchedSampling fetches a set of parcels of one block with one sample
+// protocol request per peer instead of one GetValue per parcel. The candidate
+// holders of every key are resolved first, keys are grouped by their first
+// candidate, and the keys a peer did not have move on to their next
+// candidate. Whatever is left after batchCandidateCount rounds is fetched from
+// the store. In subnet mode the candidates are the peers of the parcel's
+// subnet, parcels of this node's own subnets are read locally, and whatever
+// is left counts as failed, since the store is not seeded. With reseed set,
+// every parcel fetched is re-seeded as in per-key sampling.
+func (s *Service) BatchedSampling(ctx context.Context, store SampleStore, dht *dht.IpfsDHT, blockID int, parcels []Parcel, stats *Stats, reseed bool, nodeTypeSuffix string) {
+
+	startTime := time.Now()
+
+	var statsMu sync.Mutex
+	recordSuccess := func(ref ParcelRef, samples []byte, route RouteInfo, method string) {
+		keyHash := sha256.Sum256([]byte(ref.Key()))
+		stats.GetLatencies = append(stats.GetLatencies, time.Since(startTime))
+		stats.GetHops = append(stats.GetHops, 0)
+		stats.GetTimestamps = append(stats.GetTimestamps, time.Now())
+		stats.BlockIDs = append(stats.BlockIDs, fmt.Sprint(blockID))
+		stats.ParcelKeyHashes = append(stats.ParcelKeyHashes, fmt.Sprintf("%x", keyHash))
+		stats.ParcelStatuses = append(stats.ParcelStatuses, "success")
+		stats.Routes = append(stats.Routes, route)
+		stats.ParcelDataLengths = append(stats.ParcelDataLengths, len(samples))
+		stats.GetMethods = append(stats.GetMethods, method)
+
+		stats.TotalGetMessages += 1
+		stats.TotalSuccessGets += 1
+
+		if reseed {
+			go s.Reseed(ctx, store, dht, ref, samples, stats)
+		}
+	}
+
+	batched := make([]*batchedParcel, 0, len(parcels))
+	for _, parcel := range parcels {
+		ref := NewParcelRef(blockID, parcel)
+		if s.subnets != nil {
+			if samples, err := getLocalParcel(ctx, s.datastore, ref.Key()); err == nil {
+				recordSuccess(ref, samples, RouteInfo{Key: ref.Key()}, "subnet")
+				continue
+			}
+		}
+		batched = append(batched, &batchedParcel{ref: ref})
+	}
+
+	lookups, lookupQueries, lookupsSaved := 0, 0, 0
+	if s.subnets != nil {
+		for _, bp := range batched {
+			bp.candidates = s.subnets.samplePeers(bp.ref)
+		}
+	} else {
+		lookups, lookupQueries = s.resolveBatchCandidates(ctx, dht, batched)
+		lookupsSaved = len(batched) - lookups
+	}
+
+	batchRequests := 0
+
+	for round := 0; round < batchCandidateCount; round++ {
+		groups := make(map[peer.ID][]*batchedParcel)
+		for _, bp := range batched {
+			if !bp.found && round < len(bp.candidates) {
+				groups[bp.candidates[round]] = append(groups[bp.candidates[round]], bp)
+			}
+		}
+		if len(groups) == 0 {
+			break
+		}
+
+		var requestWg sync.WaitGroup
+		for holder, group := range groups {
+			requestWg.Add(1)
+			go func(holder peer.ID, group []*batchedParcel) {
+				defer requestWg.Done()
+
+				refs := make([]ParcelRef, len(group))
+				byKey := make(map[string]*batchedParcel, len(group))
+				for i, bp := range group {
+					refs[i] = bp.ref
+					byKey[bp.ref.Key()] = bp
+				}
+
+				requestCtx, cancel := context.WithTimeout(ctx, sampleRequestTimeout)
+				sampled, err := s.RequestParcels(requestCtx, holder, refs)
+				cancel()
+
+				statsMu.Lock()
+				defer statsMu.Unlock()
+
+				batchRequests++
+				if err != nil {
+					return
+				}
+
+				// Match the response on the parcel keys: a peer may leave out
+				// or reorder parcels.
+				for _, sp := range sampled {
+					bp, ok := byKey[sp.Key()]
+					if !ok || bp.found || !sp.Found {
+						continue
+					}
+					bp.found = true
+					s.rememberHolders(sp.Key(), holder)
+					if s.cache != nil {
+						s.cache.Add(sp.Key(), sp.Samples)
+					}
+					recordSuccess(bp.ref, sp.Samples, RouteInfo{Key: sp.Key(), Peers: []peer.ID{holder}, Holders: []peer.ID{holder}}, "batch")
+				}
+			}(holder, group)
+		}
+		requestWg.Wait()
+	}
+
+	batchedCount := 0
+	var fallbackWg sync.WaitGroup
+	for _, bp := range batched {
+		if bp.found {
+			batchedCount++
+			continue
+		}
+
+		if s.subnets != nil {
+			keyHash := sha256.Sum256([]byte(bp.ref.Key()))
+			stats.GetLatencies = append(stats.GetLatencies, time.Since(startTime))
+			stats.GetHops = append(stats.GetHops, 0)
+			stats.GetTimestamps = append(stats.GetTimestamps, time.Now())
+			stats.BlockIDs = append(stats.BlockIDs, fmt.Sprint(blockID))
+			stats.ParcelKeyHashes = append(stats.ParcelKeyHashes, fmt.Sprintf("%x", keyHash))
+			stats.ParcelStatuses = append(stats.ParcelStatuses, "fail")
+			stats.Routes = append(stats.Routes, RouteInfo{Key: bp.ref.Key()})
+			stats.ParcelDataLengths = append(stats.ParcelDataLengths, 0)
+			stats.GetMethods = append(stats.GetMethods, "subnet")
+
+			stats.TotalGetMessages += 1
+			stats.TotalFailedGets += 1
+			continue
+		}
+
+		fallbackWg.Add(1)
+		go func(bp *batchedParcel) {
+			defer fallbackWg.Done()
+
+			traceCtx, tracer := traceGet(ctx)
+			returnedPayload, err := store.Get(traceCtx, bp.ref)
+			latency := time.Since(startTime)
+			route := tracer.Finish(bp.ref.Key())
+			if err == nil {
+				route = s.findHolder(ctx, bp.ref, route)
+			}
+
+			statsMu.Lock()
+			defer statsMu.Unlock()
+
+			parcelStatus := "success"
+			if err != nil {
+				parcelStatus = "fail"
+				if err.Error() == "context deadline exceeded" {
+					parcelStatus = "timeout"
+				}
+				stats.TotalFailedGets += 1
+			} else {
+				stats.TotalSuccessGets += 1
+				if reseed {
+					go s.Reseed(ctx, store, dht, bp.ref, returnedPayload, stats)
+				}
+			}
+
+			keyHash := sha256.Sum256([]byte(bp.ref.Key()))
+			stats.GetLatencies = append(stats.GetLatencies, latency)
+			stats.GetHops = append(stats.GetHops, 0)
+			stats.GetTimestamps = append(stats.GetTimestamps, time.Now())
+			stats.BlockIDs = append(stats.BlockIDs, fmt.Sprint(blockID))
+			stats.ParcelKeyHashes = append(stats.ParcelKeyHashes, fmt.Sprintf("%x", keyHash))
+			stats.ParcelStatuses = append(stats.ParcelStatuses, parcelStatus)
+			stats.Routes = append(stats.Routes, route)
+			stats.ParcelDataLengths = append(stats.ParcelDataLengths, len(returnedPayload))
+			stats.GetMethods = append(stats.GetMethods, config.StoreType)
+
+			stats.TotalGetMessages += 1
+		}(bp)
+	}
+	fallbackWg.Wait()
+
+	// Every batched parcel would have cost its own sample request; one request
+	// per peer is what was actually paid. Likewise every parcel would have
+	// cost its own lookup; one lookup per region is what was paid.
+	requestsSaved := batchedCount - batchRequests
+	stats.BatchLookups += lookups
+	stats.LookupsSaved += lookupsSaved
+	stats.BatchLookupQueries += lookupQueries
+	stats.BatchRequests += batchRequests
+	stats.BatchedParcels += batchedCount
+	stats.RequestsSaved += requestsSaved
+
+	log.Printf(
+		"[%s - %s] Block %d: %d/%d parcels in %d batched requests after %d lookups and %d lookup queries (%d requests and %d lookups saved)\n",
+		nodeTypeSuffix,
+		s.host.ID()[0:5],
+		blockID,
+		batchedCount,
+		len(parcels),
+		batchRequests,
+		lookups,
+		lookupQueries,
+		requestsSaved,
+		lookupsSaved,
+	)
+}
+
+// resolveBatchCandidates sets the candidates of every parcel to its closest
+// peers. Keys are grouped into regions by their closest peer in the routing
+// table, and one lookup per region resolves the closest peers of all of its
+// keys. It returns the number of lookups and of DHT queries they sent.
+func (s *Service) resolveBatchCandidates(ctx context.Context, dht *dht.IpfsDHT, batched []*batchedParcel) (int, int) {
+	regions := make(map[peer.ID][]*batchedParcel)
+	for _, bp := range batched {
+		// With an empty routing table every key falls in the same region.
+		var region peer.ID
+		if nearest := dht.RoutingTable().NearestPeers(kb.ConvertKey(bp.ref.Key()), 1); len(nearest) > 0 {
+			region = nearest[0]
+		}
+		regions[region] = append(regions[region], bp)
+	}
+
+	lookupCtx, lookupCounter := countQueries(ctx)
+	var resolveWg sync.WaitGroup
+	for _, region := range regions {
+		resolveWg.Add(1)
+		go func(region []*batchedParcel) {
+			defer resolveWg.Done()
+
+			closestPeers, err := dht.GetClosestPeers(lookupCtx, region[0].ref.Key())
+			if err != nil {
+				return
+			}
+			closestPeers = FilterSelf(closestPeers, s.host.ID())
+			for _, bp := range region {
+				candidates := kb.SortClosestPeers(closestPeers, kb.ConvertKey(bp.ref.Key()))
+				if len(candidates) > batchCandidateCount {
+					candidates = candidates[:batchCandidateCount]
+				}
+				bp.candidates = candidates
+			}
+		}(region)
+	}
+	resolveWg.Wait()
+
+	return len(regions), lookupCounter.Finish()
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// exitBootstrapFailed is the exit code of a node that joined no bootstrap
+// peer within -bootstrapTimeout.
+const exitBootstrapFailed = 3
+
+const (
+	bootstrapInitialBackoff = time.Second
+	bootstrapMaxBackoff     = 30 * time.Second
+	// How long a connected bootstrap peer has to show up in the routing
+	// table, i.e. for identify to report it as a DHT server.
+	bootstrapJoinTimeout = 10 * time.Second
+)
+
+var errNoBootstrapPeer = errors.New("no valid bootstrap peer")
+
+type BootstrapResult struct {
+	Peer     peer.ID
+	Attempts int
+	Duration time.Duration
+}
+
+// Bootstrap tries every bootstrap peer in parallel, retrying each one with
+// exponential backoff, until one is connected and in the routing table. It
+// then refreshes the routing table from it. It fails once ctx is done.
+func Bootstrap(ctx context.Context, discoveryPeers addrList, h host.Host, kdht *dht.IpfsDHT, nodeTypeSuffix string) (BootstrapResult, error) {
+	start := time.Now()
+
+	attemptCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var attempts int32
+	joined := make(chan peer.ID, len(discoveryPeers))
+	var peerWg sync.WaitGroup
+	for _, peerAddr := range discoveryPeers {
+		info, err := peer.AddrInfoFromP2pAddr(peerAddr)
+		if err != nil {
+			log.Printf("[%s - %s] Skipping bootstrap peer %s: %s\n", nodeTypeSuffix, h.ID()[0:5], peerAddr, err.Error())
+			continue
+		}
+
+		peerWg.Add(1)
+		go func(info peer.AddrInfo) {
+			defer peerWg.Done()
+
+			backoff := bootstrapInitialBackoff
+			for attempt := 1; ; attempt++ {
+				atomic.AddInt32(&attempts, 1)
+				err := joinBootstrapPeer(attemptCtx, h, kdht, info)
+				if err == nil {
+					joined <- info.ID
+					return
+				}
+				if attemptCtx.Err() != nil {
+					return
+				}
+
+				log.Printf("[%s - %s] Bootstrap attempt %d to %s failed, retrying in %s: %s\n", nodeTypeSuffix, h.ID()[0:5], attempt, info.ID.String()[0:5], backoff, err.Error())
+				select {
+				case <-attemptCtx.Done():
+					return
+				case <-time.After(backoff):
+				}
+
+				backoff *= 2
+				if backoff > bootstrapMaxBackoff {
+					backoff = bootstrapMaxBackoff
+				}
+			}
+		}(*info)
+	}
+
+	allFailed := make(chan struct{})
+	go func() {
+		peerWg.Wait()
+		close(allFailed)
+	}()
+
+	result := BootstrapResult{}
+	var err error
+	select {
+	case result.Peer = <-joined:
+	case <-allFailed:
+		// Every goroutine returns on success too, so look for one last join.
+		select {
+		case result.Peer = <-joined:
+		default:
+			err = errNoBootstrapPeer
+		}
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	cancel()
+	peerWg.Wait()
+	result.Attempts = int(atomic.LoadInt32(&attempts))
+
+	if err == nil {
+		// Look up our own ID and the buckets around it, so the routing table
+		// holds more than the bootstrap peer.
+		select {
+		case refreshErr := <-kdht.ForceRefresh():
+			if refreshErr != nil {
+				log.Printf("[%s - %s] Error refreshing the routing table: %s\n", nodeTypeSuffix, h.ID()[0:5], refreshErr.Error())
+			}
+		case <-ctx.Done():
+		}
+		log.Printf("[%s - %s] Bootstrapped through %s after %d attempts (%d peers in the routing table)\n", nodeTypeSuffix, h.ID()[0:5], result.Peer.String()[0:5], result.Attempts, len(kdht.RoutingTable().ListPeers()))
+	}
+
+	result.Duration = time.Since(start)
+	return result, err
+}
+
+// joinBootstrapPeer connects to a bootstrap peer and waits for the routing
+// table to hold it.
+func joinBootstrapPeer(ctx context.Context, h host.Host, kdht *dht.IpfsDHT, info peer.AddrInfo) error {
+	if err := h.Connect(ctx, info); err != nil {
+		return err
+	}
+
+	joinCtx, cancel := context.WithTimeout(ctx, bootstrapJoinTimeout)
+	defer cancel()
+	for kdht.RoutingTable().Find(info.ID) == "" {
+		select {
+		case <-joinCtx.Done():
+			return errors.New("connected, but the peer did not join the routing table (not a DHT server?)")
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+	return nil
+}
@@ -8,11 +8,9 @@ import (
    "math/rand"
    "sync"
    "time"
-
-	dht "github.com/libp2p/go-libp2p-kad-dht"
 )
 
-func StartSeedingBlock(blockID int, blockDimension int, parcelSize int, s *Service, ctx context.Context, stats *Stats, dht *dht.IpfsDHT) {
+func StartSeedingBlock(blockID int, blockDimension int, parcelSize int, s *Service, ctx context.Context, stats *Stats, store SampleStore) {
 
    startTime := time.Now()
    allParcels := SplitSamplesIntoParcels(blockDimension, parcelSize, "all")
@@ -45,12 +43,14 @@ func StartSeedingBlock(blockID int, blockDimension int, parcelSize int, s *Servi
             //defer cancel()
 
             putStartTime := time.Now()
-            putErr := dht.PutValue(
-               ctx,
-               "/das/sample/"+fmt.Sprint(blockID)+"/"+parcelType+"/"+fmt.Sprint(p.StartingIndex),
+            traceCtx, tracer := tracePut(ctx)
+            putErr := store.Put(
+               traceCtx,
+               NewParcelRef(blockID, p),
                parcelSamplesToSend,
             )
             putLatency := time.Since(putStartTime)
+            route := tracer.Finish(NewParcelRef(blockID, p).Key())
             putTimestamp := time.Now()
 
             keyHash := sha256.Sum256([]byte("/das/sample/" + fmt.Sprint(blockID) + "/" + parcelType + "/" + fmt.Sprint(p.StartingIndex)))
@@ -67,6 +67,7 @@ func StartSeedingBlock(blockID int, blockDimension int, parcelSize int, s *Servi
                stats.BlockIDs = append(stats.BlockIDs, fmt.Sprint(blockID))
                stats.ParcelKeyHashes = append(stats.ParcelKeyHashes, keyHashString)
                stats.ParcelStatuses = append(stats.ParcelStatuses, parcelStatus)
+               stats.Routes = append(stats.Routes, route)
 
                stats.TotalFailedPuts += 1
                stats.TotalPutMessages += 1
@@ -87,6 +88,7 @@ func StartSeedingBlock(blockID int, blockDimension int, parcelSize int, s *Servi
                stats.BlockIDs = append(stats.BlockIDs, fmt.Sprint(blockID))
                stats.ParcelKeyHashes = append(stats.ParcelKeyHashes, keyHashString)
                stats.ParcelStatuses = append(stats.ParcelStatuses, "success")
+               stats.Routes = append(stats.Routes, route)
 
                stats.TotalSuccessPuts += 1
                stats.TotalPutMessages += 1
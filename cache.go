@@ -0,0 +1,114 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"sync"
+)
+
+type cachedParcel struct {
+	key     string
+	samples []byte
+}
+
+// parcelCache is a bounded LRU cache of the parcels a node fetched while
+// sampling. The sample protocol serves from it when the datastore does not
+// have the parcel, so popular parcels get more sources over time.
+type parcelCache struct {
+	mu       sync.Mutex
+	capacity int
+	order    *list.List
+	entries  map[string]*list.Element
+
+	hits      int
+	misses    int
+	evictions int
+}
+
+func NewParcelCache(capacity int) *parcelCache {
+	return &parcelCache{
+		capacity: capacity,
+		order:    list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+}
+
+func (c *parcelCache) Add(key string, samples []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if element, ok := c.entries[key]; ok {
+		c.order.MoveToFront(element)
+		element.Value.(*cachedParcel).samples = samples
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(&cachedParcel{key: key, samples: samples})
+
+	for c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cachedParcel).key)
+		c.evictions++
+	}
+}
+
+// Get returns a cached parcel and counts the lookup as a hit or a miss.
+func (c *parcelCache) Get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.entries[key]
+	if !ok {
+		c.misses++
+		return nil, false
+	}
+
+	c.hits++
+	c.order.MoveToFront(element)
+	return element.Value.(*cachedParcel).samples, true
+}
+
+// RemoveBlocksBefore drops the cached parcels of every block older than
+// oldestKept and returns how many were dropped. They are not counted as
+// evictions.
+func (c *parcelCache) RemoveBlocksBefore(oldestKept int) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	removed := 0
+	for key, element := range c.entries {
+		if blockID, ok := parcelKeyBlockID(key); ok && blockID < oldestKept {
+			c.order.Remove(element)
+			delete(c.entries, key)
+			removed++
+		}
+	}
+	return removed
+}
+
+// Stats returns the hit, miss and eviction counts and the number of cached
+// parcels.
+func (c *parcelCache) Stats() (int, int, int, int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.hits, c.misses, c.evictions, c.order.Len()
+}
+
+// cachingStore adds every parcel fetched from the wrapped store to the cache.
+type cachingStore struct {
+	SampleStore
+	cache *parcelCache
+}
+
+func NewCachingStore(store SampleStore, cache *parcelCache) SampleStore {
+	return &cachingStore{SampleStore: store, cache: cache}
+}
+
+func (c *cachingStore) Get(ctx context.Context, ref ParcelRef) ([]byte, error) {
+	samples, err := c.SampleStore.Get(ctx, ref)
+	if err == nil {
+		c.cache.Add(ref.Key(), samples)
+	}
+	return samples, err
+}
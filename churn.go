@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"log"
+	"math/rand"
+	"time"
+
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	"github.com/libp2p/go-libp2p/core/host"
+)
+
+const (
+	ChurnLeave = "leave"
+	ChurnJoin  = "join"
+)
+
+// sampleChurnDuration draws a session (or downtime) length in seconds from the
+// configured distribution. The mean of every distribution is meanSec.
+func sampleChurnDuration(distribution string, meanSec int, r *rand.Rand) time.Duration {
+	mean := float64(meanSec)
+
+	switch distribution {
+	case "fixed":
+		return time.Duration(mean * float64(time.Second))
+	case "uniform":
+		return time.Duration(r.Float64() * 2 * mean * float64(time.Second))
+	case "exponential":
+		return time.Duration(r.ExpFloat64() * mean * float64(time.Second))
+	default:
+		panic("Churn distribution not recognized: " + distribution)
+	}
+}
+
+// StartChurn alternates the node between online sessions and offline periods
+// until ctx is done. Going offline closes every connection and makes the gater
+// refuse new ones; coming back re-connects to the discovery peers and refreshes
+// the routing table.
+func StartChurn(ctx context.Context, h host.Host, dht *dht.IpfsDHT, gater *experimentGater, stats *Stats, discoveryPeers addrList, distribution string, sessionMean int, downtimeMean int, nodeTypeSuffix string, logger *log.Logger) {
+
+	source := rand.NewSource(time.Now().UnixNano())
+	randomGenerator := rand.New(source)
+
+	for {
+		session := sampleChurnDuration(distribution, sessionMean, randomGenerator)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(session):
+		}
+
+		gater.SetOffline(true)
+		for _, p := range h.Network().Peers() {
+			h.Network().ClosePeer(p)
+		}
+
+		stats.ChurnEvents = append(stats.ChurnEvents, ChurnLeave)
+		stats.ChurnTimestamps = append(stats.ChurnTimestamps, time.Now())
+		stats.ChurnDurations = append(stats.ChurnDurations, session)
+		logger.Println(formatJSONLogEvent(NodeLeft, -1))
+		log.Printf("[%s - %s] Leaving the network after %.2f seconds online\n", nodeTypeSuffix, h.ID()[0:5], session.Seconds())
+
+		downtime := sampleChurnDuration(distribution, downtimeMean, randomGenerator)
+		select {
+		case <-ctx.Done():
+			gater.SetOffline(false)
+			return
+		case <-time.After(downtime):
+		}
+
+		gater.SetOffline(false)
+		if _, err := Bootstrap(ctx, discoveryPeers, h, dht, nodeTypeSuffix); err != nil {
+			return
+		}
+
+		stats.ChurnEvents = append(stats.ChurnEvents, ChurnJoin)
+		stats.ChurnTimestamps = append(stats.ChurnTimestamps, time.Now())
+		stats.ChurnDurations = append(stats.ChurnDurations, downtime)
+		logger.Println(formatJSONLogEvent(NodeJoined, -1))
+		log.Printf("[%s - %s] Re-joined the network after %.2f seconds offline\n", nodeTypeSuffix, h.ID()[0:5], downtime.Seconds())
+	}
+}
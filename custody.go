@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// Number of attempts a node makes to acquire each parcel of its custody set.
+const custodyAcquireAttempts = 3
+
+// CustodyAssignment maps a node and a slot to the rows and columns the node
+// must fetch, hold and serve for that slot. Any node can compute the
+// assignment of any other peer.
+func CustodyAssignment(p peer.ID, blockID int, rowCount int, custodyCount int) ([]int, []int) {
+	rows := peerIndices(p, rowCount, custodyCount, fmt.Sprintf("custody/%d/row", blockID))
+	cols := peerIndices(p, rowCount, custodyCount, fmt.Sprintf("custody/%d/col", blockID))
+	return rows, cols
+}
+
+// custodyParcels returns the row parcels of the given rows and the column
+// parcels of the given columns.
+func custodyParcels(rows []int, cols []int, rowCount int, parcelSize int) []Parcel {
+	parcels := make([]Parcel, 0)
+
+	for _, row := range rows {
+		for i := row * rowCount; i < (row+1)*rowCount; i += parcelSize {
+			parcels = append(parcels, Parcel{StartingIndex: i, SampleCount: parcelSize, IsRow: true})
+		}
+	}
+
+	for _, col := range cols {
+		for rowID := 0; rowID < rowCount; rowID += parcelSize {
+			parcels = append(parcels, Parcel{StartingIndex: rowID*rowCount + col, SampleCount: parcelSize, IsRow: false})
+		}
+	}
+
+	return parcels
+}
+
+// isCustodian returns true if p has the parcel in its custody set.
+func isCustodian(p peer.ID, ref ParcelRef, rowCount int, custodyCount int) bool {
+	rows, cols := CustodyAssignment(p, ref.BlockID, rowCount, custodyCount)
+	if ref.IsRow {
+		return contains(rows, ref.StartingIndex/rowCount)
+	}
+	return contains(cols, ref.StartingIndex%rowCount)
+}
+
+// SampleFromCustodians asks the connected peers whose custody set holds the
+// parcel for it over the sample protocol.
+func (s *Service) SampleFromCustodians(ctx context.Context, ref ParcelRef, rowCount int, custodyCount int) ([]byte, error) {
+	for _, p := range s.host.Network().Peers() {
+		if !isCustodian(p, ref, rowCount, custodyCount) {
+			continue
+		}
+
+		requestCtx, cancel := context.WithTimeout(ctx, sampleRequestTimeout)
+		parcels, err := s.RequestParcels(requestCtx, p, []ParcelRef{ref})
+		cancel()
+
+		if err == nil && len(parcels) == 1 && parcels[0].Found {
+			return parcels[0].Samples, nil
+		}
+	}
+
+	return nil, errParcelNotFound
+}
+
+// AcquireCustody fetches every parcel of this node's custody set for a block
+// and keeps it in the datastore, where the sample protocol serves it from.
+func (s *Service) AcquireCustody(ctx context.Context, store SampleStore, dht *dht.IpfsDHT, blockID int, rowCount int, parcelSize int, custodyCount int, stats *Stats, nodeTypeSuffix string) {
+
+	startTime := time.Now()
+
+	rows, cols := CustodyAssignment(s.host.ID(), blockID, rowCount, custodyCount)
+	parcels := custodyParcels(rows, cols, rowCount, parcelSize)
+
+	log.Printf("[%s - %s] Acquiring custody of rows %v and columns %v (%d parcels) for block %d...\n", nodeTypeSuffix, s.host.ID()[0:5], rows, cols, len(parcels), blockID)
+
+	acquiredCount := 0
+	var acquiredMu sync.Mutex
+	var parcelWg sync.WaitGroup
+	for _, parcel := range parcels {
+		parcelWg.Add(1)
+		go func(p Parcel) {
+			defer parcelWg.Done()
+
+			ref := NewParcelRef(blockID, p)
+			for attempt := 0; attempt < custodyAcquireAttempts; attempt++ {
+				samples, _, err := s.SampleDirect(ctx, dht, ref)
+				if err != nil {
+					samples, err = store.Get(ctx, ref)
+				}
+				if err == nil {
+					if err := putLocalParcel(ctx, s.datastore, ref.Key(), samples); err != nil {
+						log.Printf("[%s - %s] Failed to store custody parcel %s: %s\n", nodeTypeSuffix, s.host.ID()[0:5], ref.Key(), err.Error())
+						return
+					}
+					acquiredMu.Lock()
+					acquiredCount++
+					acquiredMu.Unlock()
+					return
+				}
+
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(1 * time.Second):
+				}
+			}
+		}(parcel)
+	}
+	parcelWg.Wait()
+
+	// Custody of several blocks can be acquired at once, and the custody
+	// stats are parallel slices.
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats.CustodyBlockIDs = append(stats.CustodyBlockIDs, blockID)
+	stats.CustodyRows = append(stats.CustodyRows, rows)
+	stats.CustodyCols = append(stats.CustodyCols, cols)
+	stats.CustodyAssignedParcels = append(stats.CustodyAssignedParcels, len(parcels))
+	stats.CustodyAcquiredParcels = append(stats.CustodyAcquiredParcels, acquiredCount)
+	stats.CustodyLatencies = append(stats.CustodyLatencies, time.Since(startTime))
+
+	log.Printf("[%s - %s] Block %d custody: %d/%d parcels acquired in %.2f seconds.\n", nodeTypeSuffix, s.host.ID()[0:5], blockID, acquiredCount, len(parcels), time.Since(startTime).Seconds())
+}
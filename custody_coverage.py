@@ -0,0 +1,74 @@
+import os
+import sys
+import pandas as pd
+from rich.console import Console
+
+console = Console()
+
+ROW_COUNT = 512
+
+def parse_indices(value):
+    if pd.isna(value) or str(value).strip() == "":
+        return set()
+    return set(int(i) for i in str(value).split())
+
+def get_custody_coverage(log_dir):
+    """
+    Merges the <peer>_custody_<nodeType>.csv files of an experiment and returns,
+    for every block, the share of rows and columns that at least one node holds
+    completely.
+
+    Parameters:
+    log_dir (str): The directory the node logs were written to.
+
+    Returns:
+    DataFrame: One row per block.
+    """
+    custody_files = [os.path.join(log_dir, f) for f in os.listdir(log_dir) if "_custody_" in f and f.endswith(".csv")]
+    if len(custody_files) == 0:
+        console.print(f"No custody files found in {log_dir}")
+        return None
+
+    blocks = {}
+    for custody_file in custody_files:
+        df = pd.read_csv(custody_file)
+        for _, row in df.iterrows():
+            block = blocks.setdefault(int(row["Block ID"]), {"nodes": 0, "complete_nodes": 0, "rows": set(), "cols": set()})
+            block["nodes"] += 1
+
+            if row["Acquired Parcels"] < row["Assigned Parcels"]:
+                continue
+
+            block["complete_nodes"] += 1
+            block["rows"] |= parse_indices(row["Custody Rows"])
+            block["cols"] |= parse_indices(row["Custody Cols"])
+
+    coverage = []
+    for block_id, block in sorted(blocks.items()):
+        coverage.append({
+            "Block ID": block_id,
+            "Custody Nodes": block["nodes"],
+            "Complete Custody Nodes": block["complete_nodes"],
+            "Rows Covered": len(block["rows"]),
+            "Cols Covered": len(block["cols"]),
+            "Row Coverage": len(block["rows"]) / ROW_COUNT,
+            "Col Coverage": len(block["cols"]) / ROW_COUNT,
+        })
+
+    return pd.DataFrame(coverage)
+
+if __name__ == "__main__":
+
+    if len(sys.argv) < 2:
+        console.print("Usage: python custody_coverage.py <log_dir>")
+        sys.exit(1)
+
+    log_dir = sys.argv[1]
+    coverage = get_custody_coverage(log_dir)
+    if coverage is None:
+        sys.exit(1)
+
+    output_path = os.path.join(log_dir, "custody_coverage.csv")
+    coverage.to_csv(output_path, index=False)
+    console.print(coverage)
+    console.print(f"Custody coverage written to {output_path}")
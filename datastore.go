@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+
+	ds "github.com/ipfs/go-datastore"
+	dsq "github.com/ipfs/go-datastore/query"
+	dssync "github.com/ipfs/go-datastore/sync"
+	leveldb "github.com/ipfs/go-ds-leveldb"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// NewDatastore opens the datastore backing the DHT. "memory" keeps records in
+// a map for the lifetime of the process; "leveldb" keeps them on disk under
+// <dir>/<peer ID>, so a node started again with the same identity (-seed)
+// reopens the records it stored before.
+func NewDatastore(storeType string, dir string, self peer.ID) (ds.Batching, error) {
+	switch storeType {
+	case "memory":
+		return dssync.MutexWrap(ds.NewMapDatastore()), nil
+	case "leveldb":
+		return leveldb.NewDatastore(filepath.Join(dir, self.String()), nil)
+	default:
+		return nil, fmt.Errorf("unknown datastore type %q", storeType)
+	}
+}
+
+// datastoreSize returns the number of records in the datastore and the total
+// size of their values in bytes.
+func datastoreSize(ctx context.Context, dstore ds.Batching) (int, int64, error) {
+	results, err := dstore.Query(ctx, dsq.Query{})
+	if err != nil {
+		return 0, 0, err
+	}
+	defer results.Close()
+
+	count := 0
+	var size int64
+	for result := range results.Next() {
+		if result.Error != nil {
+			return count, size, result.Error
+		}
+		count++
+		size += int64(len(result.Value))
+	}
+	return count, size, nil
+}
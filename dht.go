@@ -2,10 +2,21 @@ package main
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
 	"log"
+	"time"
 
+	"github.com/gogo/protobuf/proto"
+	ds "github.com/ipfs/go-datastore"
 	dht "github.com/libp2p/go-libp2p-kad-dht"
+	record "github.com/libp2p/go-libp2p-record"
+	recpb "github.com/libp2p/go-libp2p-record/pb"
    "github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/multiformats/go-base32"
 )
 
 type blankValidator struct{}
@@ -15,17 +26,26 @@ func (blankValidator) Select(_ string, _ [][]byte) (int, error) { return 0, nil
 
 var testPrefix = dht.ProtocolPrefix("/das")
 
-func NewDHT(ctx context.Context, host host.Host, nodeType string) (*dht.IpfsDHT, error) {
+// kadProtocolID is the protocol DHT servers answer queries on.
+const kadProtocolID = protocol.ID("/das/kad/1.0.0")
+
+func NewDHT(ctx context.Context, host host.Host, nodeType string, dstore ds.Batching) (*dht.IpfsDHT, error) {
 	var options []dht.Option
 
-	if nodeType == "nonvalidator" {
-		options = append(options, dht.Mode(dht.ModeClient))
-	} else {
-		options = append(options, dht.Mode(dht.ModeServer))
+	mode, err := dhtModeFor(host.ID(), nodeType)
+	if err != nil {
+		return nil, err
 	}
+	options = append(options, dht.Mode(mode))
 
 	options = append(options, dht.NamespacedValidator("das", blankValidator{}))
 	options = append(options, testPrefix)
+	options = append(options, dht.Datastore(dstore))
+	options = append(options, dht.BucketSize(config.DHTBucketSize))
+	options = append(options, dht.Concurrency(config.DHTAlpha))
+	options = append(options, dht.Resiliency(config.DHTBeta))
+	options = append(options, dht.RoutingTableRefreshPeriod(time.Duration(config.DHTRefreshInterval)*time.Second))
+	options = append(options, dht.RoutingTableRefreshQueryTimeout(time.Duration(config.DHTRefreshQueryTimeout)*time.Second))
 
 	kdht, err := dht.New(ctx, host, options...)
 	if err != nil {
@@ -40,3 +60,90 @@ func NewDHT(ctx context.Context, host host.Host, nodeType string) (*dht.IpfsDHT,
 
 	return kdht, nil
 }
+
+// configuredDHTMode returns the -validatorDHTMode / -regularDHTMode setting
+// of a node type. The builder and bootstrap nodes always answer DHT queries,
+// and a node picked for -regularServerPercent runs as a server whatever
+// -regularDHTMode says.
+func configuredDHTMode(self peer.ID, nodeType string) string {
+	switch nodeType {
+	case "builder", "bootstrap":
+		return "server"
+	case "validator":
+		return config.ValidatorDHTMode
+	}
+
+	hash := sha256.Sum256([]byte("dht-server/" + self))
+	if binary.BigEndian.Uint64(hash[0:8])%100 < uint64(config.RegularServerPercent) {
+		return "server"
+	}
+	return config.RegularDHTMode
+}
+
+func dhtModeFor(self peer.ID, nodeType string) (dht.ModeOpt, error) {
+	switch mode := configuredDHTMode(self, nodeType); mode {
+	case "client":
+		return dht.ModeClient, nil
+	case "server":
+		return dht.ModeServer, nil
+	case "auto":
+		return dht.ModeAuto, nil
+	default:
+		return dht.ModeClient, fmt.Errorf("unknown DHT mode %q", mode)
+	}
+}
+
+// parcelDsKey is the datastore key the DHT uses for a record key.
+func parcelDsKey(key string) ds.Key {
+	return ds.NewKey(base32.RawStdEncoding.EncodeToString([]byte(key)))
+}
+
+// putLocalParcel stores a parcel in the DHT datastore in the same format as a
+// PUT_VALUE received from another peer, so it can be served to GetValue.
+func putLocalParcel(ctx context.Context, dstore ds.Batching, key string, value []byte) error {
+	rec := record.MakePutRecord(key, value)
+	rec.TimeReceived = time.Now().UTC().Format(time.RFC3339Nano)
+
+	data, err := proto.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	return dstore.Put(ctx, parcelDsKey(key), data)
+}
+
+// getLocalParcel reads a parcel stored by the DHT or by putLocalParcel.
+func getLocalParcel(ctx context.Context, dstore ds.Batching, key string) ([]byte, error) {
+	data, err := dstore.Get(ctx, parcelDsKey(key))
+	if err != nil {
+		return nil, err
+	}
+
+	rec := new(recpb.Record)
+	if err := proto.Unmarshal(data, rec); err != nil {
+		return nil, err
+	}
+
+	return rec.GetValue(), nil
+}
+
+// isDHTServer returns true if the node currently answers DHT queries and so
+// stores records for other peers. In auto mode the DHT only registers its
+// protocol while it acts as a server.
+func isDHTServer(kdht *dht.IpfsDHT) bool {
+	for _, p := range kdht.Host().Mux().Protocols() {
+		if p == kadProtocolID {
+			return true
+		}
+	}
+	return false
+}
+
+// dhtModeName is the mode the node's DHT currently runs in, which can change
+// over time in auto mode.
+func dhtModeName(kdht *dht.IpfsDHT) string {
+	if isDHTServer(kdht) {
+		return "server"
+	}
+	return "client"
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+)
+
+const (
+	FaultPartition = "partition"
+	FaultDrop      = "drop"
+	FaultDelay     = "delay"
+)
+
+var errStreamDropped = errors.New("stream dropped by fault injection")
+
+// FaultEvent is one entry of the fault schedule given with -faults. Start and
+// Duration are in seconds from the moment the node starts messaging.
+type FaultEvent struct {
+	Type     string  `json:"type"`
+	Start    int     `json:"start"`
+	Duration int     `json:"duration"`
+	Fraction float64 `json:"fraction"` // partition: share of the peers cut off from the rest
+	DropRate float64 `json:"dropRate"` // drop: probability that a new stream is refused
+	DelayMs  int     `json:"delayMs"`  // delay: added before every new connection
+}
+
+func (e FaultEvent) String() string {
+	switch e.Type {
+	case FaultPartition:
+		return fmt.Sprintf("fraction=%.2f", e.Fraction)
+	case FaultDrop:
+		return fmt.Sprintf("dropRate=%.2f", e.DropRate)
+	case FaultDelay:
+		return fmt.Sprintf("delayMs=%d", e.DelayMs)
+	}
+	return ""
+}
+
+func loadFaultSchedule(path string) ([]FaultEvent, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var schedule []FaultEvent
+	if err := json.Unmarshal(data, &schedule); err != nil {
+		return nil, err
+	}
+
+	for _, event := range schedule {
+		if event.Type != FaultPartition && event.Type != FaultDrop && event.Type != FaultDelay {
+			return nil, fmt.Errorf("fault type not recognized: %s", event.Type)
+		}
+	}
+
+	return schedule, nil
+}
+
+// faultInjector holds the faults that are currently active on this node.
+// Faults of the same type may overlap; the most severe active one applies.
+type faultInjector struct {
+	mu             sync.Mutex
+	gater          *experimentGater
+	active         []FaultEvent
+	dropRate       float64
+	delay          time.Duration
+	droppedStreams int
+	random         *rand.Rand
+}
+
+func NewFaultInjector(gater *experimentGater) *faultInjector {
+	return &faultInjector{
+		gater:  gater,
+		random: rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+func (f *faultInjector) shouldDrop() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.dropRate > 0 && f.random.Float64() < f.dropRate {
+		f.droppedStreams++
+		return true
+	}
+	return false
+}
+
+func (f *faultInjector) currentDelay() time.Duration {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.delay
+}
+
+func (f *faultInjector) apply(h host.Host, event FaultEvent, active bool) {
+	f.mu.Lock()
+	if active {
+		f.active = append(f.active, event)
+	} else {
+		for i, e := range f.active {
+			if e == event {
+				f.active = append(f.active[:i], f.active[i+1:]...)
+				break
+			}
+		}
+	}
+
+	fraction := 0.0
+	f.dropRate = 0
+	f.delay = 0
+	for _, e := range f.active {
+		switch e.Type {
+		case FaultPartition:
+			fraction = math.Max(fraction, e.Fraction)
+		case FaultDrop:
+			f.dropRate = math.Max(f.dropRate, e.DropRate)
+		case FaultDelay:
+			if delay := time.Duration(e.DelayMs) * time.Millisecond; delay > f.delay {
+				f.delay = delay
+			}
+		}
+	}
+	f.mu.Unlock()
+
+	if event.Type == FaultPartition {
+		f.gater.SetPartition(fraction)
+		for _, p := range h.Network().Peers() {
+			if f.gater.IsCutOff(p) {
+				h.Network().ClosePeer(p)
+			}
+		}
+	}
+}
+
+// faultyHost wraps the libp2p host handed to the DHT, pubsub and RPC so that
+// outgoing streams and connections go through the fault injector.
+type faultyHost struct {
+	host.Host
+	faults *faultInjector
+}
+
+func NewFaultyHost(h host.Host, faults *faultInjector) host.Host {
+	return &faultyHost{Host: h, faults: faults}
+}
+
+func (h *faultyHost) Connect(ctx context.Context, pi peer.AddrInfo) error {
+	if delay := h.faults.currentDelay(); delay > 0 && h.Network().Connectedness(pi.ID) != network.Connected {
+		time.Sleep(delay)
+	}
+	return h.Host.Connect(ctx, pi)
+}
+
+func (h *faultyHost) NewStream(ctx context.Context, p peer.ID, pids ...protocol.ID) (network.Stream, error) {
+	if h.faults.shouldDrop() {
+		return nil, errStreamDropped
+	}
+	if delay := h.faults.currentDelay(); delay > 0 && h.Network().Connectedness(p) != network.Connected {
+		time.Sleep(delay)
+	}
+	return h.Host.NewStream(ctx, p, pids...)
+}
+
+// StartFaultSchedule activates and clears every fault of the schedule at its
+// configured time and records the timeline in stats. Faults still active when
+// the experiment ends are recorded without an end timestamp.
+func StartFaultSchedule(ctx context.Context, h host.Host, faults *faultInjector, schedule []FaultEvent, stats *Stats, nodeTypeSuffix string) {
+	startTime := time.Now()
+
+	var faultWg sync.WaitGroup
+	for _, event := range schedule {
+		faultWg.Add(1)
+		go func(e FaultEvent) {
+			defer faultWg.Done()
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(time.Until(startTime.Add(time.Duration(e.Start) * time.Second))):
+			}
+
+			faults.mu.Lock()
+			timelineIndex := len(stats.FaultTypes)
+			droppedBefore := faults.droppedStreams
+			stats.FaultTypes = append(stats.FaultTypes, e.Type)
+			stats.FaultParameters = append(stats.FaultParameters, e.String())
+			stats.FaultStartTimestamps = append(stats.FaultStartTimestamps, time.Now())
+			stats.FaultEndTimestamps = append(stats.FaultEndTimestamps, time.Time{})
+			stats.FaultDroppedStreams = append(stats.FaultDroppedStreams, 0)
+			faults.mu.Unlock()
+
+			faults.apply(h, e, true)
+			log.Printf("[%s - %s] Fault %s started (%s)\n", nodeTypeSuffix, h.ID()[0:5], e.Type, e)
+
+			select {
+			case <-ctx.Done():
+			case <-time.After(time.Duration(e.Duration) * time.Second):
+			}
+
+			faults.apply(h, e, false)
+			log.Printf("[%s - %s] Fault %s ended (%s)\n", nodeTypeSuffix, h.ID()[0:5], e.Type, e)
+
+			faults.mu.Lock()
+			stats.FaultEndTimestamps[timelineIndex] = time.Now()
+			stats.FaultDroppedStreams[timelineIndex] = faults.droppedStreams - droppedBefore
+			faults.mu.Unlock()
+		}(event)
+	}
+	faultWg.Wait()
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	ds "github.com/ipfs/go-datastore"
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	"github.com/libp2p/go-libp2p-kad-dht/fullrt"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/routing"
+)
+
+// How long the accelerated client may take to finish its first crawl.
+const acceleratedCrawlTimeout = 120 * time.Second
+
+var (
+	errNoCrawlPeers = errors.New("no peers in the routing table to start the crawl from")
+	errCrawlTimeout = errors.New("first crawl of the network did not finish in time")
+)
+
+// valueStore is the part of a DHT client the dht sample store uses. Both the
+// standard client and the full routing table client implement it.
+type valueStore interface {
+	PutValue(ctx context.Context, key string, value []byte, opts ...routing.Option) error
+	GetValue(ctx context.Context, key string, opts ...routing.Option) ([]byte, error)
+}
+
+// acceleratedFor returns true if -accelerated asks for the full routing table
+// client on this node type.
+func acceleratedFor(nodeType string) bool {
+	switch config.AcceleratedDHT {
+	case "builder":
+		return nodeType == "builder"
+	case "validators":
+		return nodeType == "builder" || nodeType == "validator"
+	}
+	return false
+}
+
+// NewAcceleratedClient starts a full routing table client next to the
+// standard DHT. It crawls the whole network, starting from the peers of the
+// standard routing table, and then puts and gets records in one hop to the
+// closest peers. It re-crawls every -crawlInterval seconds, so a client
+// started while the network is still forming catches up with the nodes that
+// join later. It returns once the first crawl has finished.
+func NewAcceleratedClient(ctx context.Context, h host.Host, kdht *dht.IpfsDHT, dstore ds.Batching) (*fullrt.FullRT, error) {
+	var crawlPeers []peer.AddrInfo
+	for _, p := range kdht.RoutingTable().ListPeers() {
+		crawlPeers = append(crawlPeers, h.Peerstore().PeerInfo(p))
+	}
+	if len(crawlPeers) == 0 {
+		return nil, errNoCrawlPeers
+	}
+
+	frt, err := fullrt.NewFullRT(h, "/das", fullrt.DHTOption(
+		dht.NamespacedValidator("das", blankValidator{}),
+		dht.Datastore(dstore),
+		dht.BucketSize(config.DHTBucketSize),
+		dht.BootstrapPeers(crawlPeers...),
+	), fullrt.WithCrawlInterval(time.Duration(config.CrawlInterval)*time.Second))
+	if err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(acceleratedCrawlTimeout)
+	for len(frt.Stat()) == 0 {
+		if time.Now().After(deadline) {
+			frt.Close()
+			return nil, errCrawlTimeout
+		}
+
+		select {
+		case <-ctx.Done():
+			frt.Close()
+			return nil, ctx.Err()
+		case <-time.After(1 * time.Second):
+		}
+	}
+
+	return frt, nil
+}
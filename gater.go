@@ -0,0 +1,100 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"math"
+	"sync"
+
+	"github.com/libp2p/go-libp2p/core/control"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+)
+
+// experimentGater is the connection gater installed on every host. It lets the
+// experiment cut a node off from the network (e.g. while it is churned out)
+// or split the peer set in two without tearing down the host and the DHT state.
+type experimentGater struct {
+	mu      sync.RWMutex
+	self    peer.ID
+	offline bool
+
+	partitioned       bool
+	partitionFraction float64
+}
+
+func NewExperimentGater() *experimentGater {
+	return &experimentGater{}
+}
+
+// SetSelf must be called once the host exists so that partitions know which
+// side this node is on.
+func (g *experimentGater) SetSelf(self peer.ID) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.self = self
+}
+
+func (g *experimentGater) SetOffline(offline bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.offline = offline
+}
+
+func (g *experimentGater) IsOffline() bool {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return g.offline
+}
+
+// SetPartition splits the network so that the peers whose ID hashes below
+// fraction can only talk among themselves. A fraction of 0 heals the partition.
+func (g *experimentGater) SetPartition(fraction float64) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.partitioned = fraction > 0
+	g.partitionFraction = fraction
+}
+
+// partitionSide returns true if p is on the minority side of a partition of the
+// given fraction. Every node computes the same side for the same peer.
+func partitionSide(p peer.ID, fraction float64) bool {
+	hash := sha256.Sum256([]byte(p))
+	position := float64(binary.BigEndian.Uint64(hash[0:8])) / float64(math.MaxUint64)
+	return position < fraction
+}
+
+// IsCutOff returns true if p cannot be reached from this node.
+func (g *experimentGater) IsCutOff(p peer.ID) bool {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	if g.offline {
+		return true
+	}
+	if g.partitioned && p != g.self {
+		return partitionSide(p, g.partitionFraction) != partitionSide(g.self, g.partitionFraction)
+	}
+	return false
+}
+
+func (g *experimentGater) InterceptPeerDial(p peer.ID) bool {
+	return !g.IsCutOff(p)
+}
+
+func (g *experimentGater) InterceptAddrDial(p peer.ID, addr multiaddr.Multiaddr) bool {
+	return !g.IsCutOff(p)
+}
+
+func (g *experimentGater) InterceptAccept(addrs network.ConnMultiaddrs) bool {
+	return !g.IsOffline()
+}
+
+func (g *experimentGater) InterceptSecured(dir network.Direction, p peer.ID, addrs network.ConnMultiaddrs) bool {
+	return !g.IsCutOff(p)
+}
+
+func (g *experimentGater) InterceptUpgraded(conn network.Conn) (bool, control.DisconnectReason) {
+	return !g.IsCutOff(conn.RemotePeer()), 0
+}
@@ -10,6 +10,8 @@ const (
     HeaderSent EventCode = iota
     HeaderReceived
     SamplingFinished
+    NodeLeft
+    NodeJoined
 )
     
     
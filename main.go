@@ -3,7 +3,6 @@ package main
 import (
 	"context"
 	"crypto/rand"
-	randMath "math/rand"
 	"encoding/csv"
 	"flag"
 	"fmt"
@@ -13,15 +12,12 @@ import (
 	"os"
 	"strconv"
 	"strings"
-	"sync"
 	"time"
 
 	"github.com/libp2p/go-libp2p"
-	dht "github.com/libp2p/go-libp2p-kad-dht"
 	"github.com/libp2p/go-libp2p/core/crypto"
 	"github.com/libp2p/go-libp2p/core/host"
-	"github.com/libp2p/go-libp2p/core/network"
-	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/metrics"
 	"github.com/libp2p/go-libp2p/core/protocol"
 
 	//discovery "github.com/libp2p/go-libp2p-discovery"
@@ -43,6 +39,57 @@ type Config struct {
 	LogDirectory       string
 	PerfMode           bool
 	NickFlag           string
+	StoreType          string
+	SeedMode           string
+	SampleFastPath     bool
+	TraceHolders       bool
+	BatchGet           bool
+	SubnetCount        int
+	SubnetsPerNode     int
+	CustodyCount       int
+	ReseedMode         string
+	CacheSize          int
+	DatastoreType      string
+	DatastorePath      string
+	RetentionSlots     int
+	AuditInterval      int
+	AuditSampleCount   int
+	AuditWindow        int
+	StorageInterval    int
+	SnapshotInterval   int
+
+	// Readiness
+	ExpectedNodes int
+	ReadyFraction float64
+	ReadyTimeout  int
+
+	// Bootstrap
+	BootstrapTimeout int
+
+	// DHT
+	DHTBucketSize          int
+	DHTAlpha               int
+	DHTBeta                int
+	DHTRefreshInterval     int
+	DHTRefreshQueryTimeout int
+	DHTQueryTimeout        int
+	AcceleratedDHT         string
+	CrawlInterval          int
+	ValidatorDHTMode       string
+	RegularDHTMode         string
+	RegularServerPercent   int
+
+	// Churn
+	ChurnEnabled      bool
+	ChurnDistribution string
+	ChurnSessionMean  int
+	ChurnDowntimeMean int
+
+	// Fault injection
+	FaultSchedulePath string
+
+	// Network emulation
+	NetemConfigPath string
 }
 
 type Stats struct {
@@ -50,13 +97,15 @@ type Stats struct {
 	BlockIDs          []string
 	ParcelKeyHashes   []string
 	ParcelStatuses    []string
+	Routes            []RouteInfo
 	ParcelDataLengths []int
 	PutTimestamps     []time.Time
 	PutLatencies      []time.Duration
 	GetTimestamps     []time.Time
 	GetLatencies      []time.Duration
 
-	GetHops []int
+	GetHops    []int
+	GetMethods []string
 
 	// Total Stats
 	TotalPutMessages int
@@ -66,12 +115,80 @@ type Stats struct {
 	TotalFailedGets  int
 	TotalSuccessGets int
 
+	// Batched GETs
+	BatchRequests      int
+	BatchedParcels     int
+	RequestsSaved      int
+	BatchLookups       int
+	LookupsSaved       int
+	BatchLookupQueries int
+
+	// Load
+	TotalReseededParcels int
+	TotalFailedReseeds   int
+	TotalServedParcels   int
+	CacheHits            int
+	CacheMisses          int
+	CacheEvictions       int
+	CachedParcels        int
+	InitialStoredRecords int
+	StoredRecords        int
+	StoredBytes          int64
+	TotalPrunedRecords   int
+	TotalPrunedBytes     int64
+	TotalBytesIn         int64
+	TotalBytesOut        int64
+
 	// Latencies
 	SeedingLatencies        []time.Duration
 	RowSamplingLatencies    []time.Duration
 	ColSamplingLatencies    []time.Duration
 	RandomSamplingLatencies []time.Duration
 	TotalSamplingLatencies  []time.Duration
+
+	// Churn
+	ChurnEvents     []string
+	ChurnTimestamps []time.Time
+	ChurnDurations  []time.Duration
+
+	// Custody
+	CustodyBlockIDs        []int
+	CustodyRows            [][]int
+	CustodyCols            [][]int
+	CustodyAssignedParcels []int
+	CustodyAcquiredParcels []int
+	CustodyLatencies       []time.Duration
+
+	// Faults
+	FaultTypes           []string
+	FaultParameters      []string
+	FaultStartTimestamps []time.Time
+	FaultEndTimestamps   []time.Time
+	FaultDroppedStreams  []int
+
+	// Durability
+	DurabilityTimestamps         []time.Time
+	DurabilityBlockIDs           []int
+	DurabilityBlockAges          []time.Duration
+	DurabilityAuditedParcels     []int
+	DurabilityRetrievableParcels []int
+	DurabilityHolders            []int
+	DurabilityRepairedParcels    []int
+
+	// Storage
+	StorageTimestamps []time.Time
+	StorageBlockIDs   []int
+	StorageRecords    []int
+	StorageBytes      []int64
+
+	// Bootstrap
+	BootstrapAttempts int
+	BootstrapDuration time.Duration
+
+	// Readiness
+	ReadyAt             time.Time
+	ProductionStartedAt time.Time
+	ReadyNodesAtStart   int
 }
 
 var config Config
@@ -84,10 +201,10 @@ func main() {
 	stats := &Stats{}
 
 	// flag.StringVar(&config.Rendezvous, "rendezvous", "/das", "")
-	flag.StringVar(&config.NodeType, "nodeType", "validator", "The node type to run (validator, nonvalidator, builder)")
+	flag.StringVar(&config.NodeType, "nodeType", "validator", "The node type to run (validator, nonvalidator, builder, bootstrap)")
 	flag.IntVar(&config.ParcelSize, "parcelSize", 512, "The size of the parcels to send - make sure 512 divides evenly by this number")
 	flag.Int64Var(&config.Seed, "seed", 0, "Seed value for generating a PeerID, 0 is random")
-	flag.Var(&config.DiscoveryPeers, "peer", "Peer multiaddress for peer discovery")
+	flag.Var(&config.DiscoveryPeers, "peer", "Bootstrap peer multiaddress, repeat or comma-separate for several")
 	flag.StringVar(&config.ProtocolID, "protocolid", "/p2p/rpc", "")
 	flag.IntVar(&config.Port, "port", 0, "")
 	flag.IntVar(&config.ExperimentDuration, "duration", 180, "Experiment duration (in seconds).")
@@ -95,8 +212,96 @@ func main() {
 	flag.StringVar(&config.LogDirectory, "log", "./log/", "Log Directory")
 	flag.StringVar(&config.NickFlag, "nick", "", "nickname for node")
 	flag.BoolVar(&config.PerfMode, "pref", false, "perf")
+	flag.StringVar(&config.StoreType, "store", "dht", "Overlay parcels are stored in (dht: Kademlia value records, push: custody RPC to the closest peers, provider: kept by the builder and announced with provider records)")
+	flag.StringVar(&config.SeedMode, "seedMode", "", "Deprecated, use -store: -seedMode dht is -store dht, -seedMode push is -store push")
+	flag.BoolVar(&config.BatchGet, "batchGet", false, "Resolve the closest peers of all sampled keys and fetch them with one sample protocol request per peer")
+	flag.IntVar(&config.SubnetCount, "subnets", 0, "Number of row (and of column) gossip subnets the builder publishes parcels on instead of seeding the store, 0 disables subnets")
+	flag.IntVar(&config.SubnetsPerNode, "subnetsPerNode", 2, "Number of row and of column subnets each node subscribes to")
+	flag.IntVar(&config.CustodyCount, "custody", 0, "Number of rows and of columns each node takes custody of per block, 0 disables custody")
+	flag.StringVar(&config.DatastoreType, "datastore", "memory", "Datastore backing the DHT (memory, leveldb)")
+	flag.StringVar(&config.DatastorePath, "datastorePath", "./datastore/", "Directory on-disk datastores are kept in, one sub-directory per peer")
+	flag.IntVar(&config.RetentionSlots, "retention", 0, "Number of most recent blocks whose parcels are kept in the datastore, 0 keeps every block")
+	flag.IntVar(&config.AuditInterval, "audit", 0, "Seconds between two durability audits of recent blocks by the builder, 0 disables the audit")
+	flag.IntVar(&config.AuditSampleCount, "auditSample", 16, "Number of random parcels of each block checked per audit")
+	flag.IntVar(&config.AuditWindow, "auditWindow", 4, "Number of most recent blocks checked per audit")
+	flag.IntVar(&config.StorageInterval, "storageReport", 0, "Seconds between two records of the parcels held by a DHT server, 0 disables the report")
+	flag.IntVar(&config.DHTBucketSize, "dhtBucketSize", 20, "Kademlia bucket size (k)")
+	flag.IntVar(&config.DHTAlpha, "dhtAlpha", 10, "Kademlia lookup concurrency (alpha)")
+	flag.IntVar(&config.DHTBeta, "dhtBeta", 3, "Kademlia lookup resiliency (beta)")
+	flag.IntVar(&config.DHTRefreshInterval, "dhtRefresh", 600, "Seconds between two routing table refreshes")
+	flag.IntVar(&config.DHTRefreshQueryTimeout, "dhtRefreshTimeout", 10, "Timeout in seconds of the queries of a routing table refresh")
+	flag.IntVar(&config.DHTQueryTimeout, "dhtQueryTimeout", 0, "Timeout in seconds of every DHT PUT and GET of the dht store, 0 for no timeout")
+	flag.StringVar(&config.AcceleratedDHT, "accelerated", "off", "Nodes that put and get parcels through the full routing table DHT client (off, builder, validators: builder and validators)")
+	flag.IntVar(&config.CrawlInterval, "crawlInterval", 60, "Seconds between two crawls of the network by the accelerated DHT client (5 block times by default)")
+	flag.StringVar(&config.ValidatorDHTMode, "validatorDHTMode", "server", "DHT mode of validators (client, server, auto)")
+	flag.StringVar(&config.RegularDHTMode, "regularDHTMode", "client", "DHT mode of regular nodes (client, server, auto)")
+	flag.IntVar(&config.RegularServerPercent, "regularServerPercent", 0, "Percentage of regular nodes, picked by peer ID, that run as DHT servers whatever -regularDHTMode says")
+	flag.IntVar(&config.BootstrapTimeout, "bootstrapTimeout", 300, "Seconds a node keeps trying to join a bootstrap peer before exiting with code 3")
+	flag.IntVar(&config.ExpectedNodes, "expectedNodes", 0, "Number of validators and regular nodes the builder waits for before producing blocks, 0 waits the whole -readyTimeout")
+	flag.Float64Var(&config.ReadyFraction, "readyFraction", 1.0, "Fraction of -expectedNodes that must be ready before the builder produces blocks")
+	flag.IntVar(&config.ReadyTimeout, "readyTimeout", 180, "Seconds after which the builder produces blocks however many nodes are ready")
+	flag.IntVar(&config.SnapshotInterval, "rtSnapshot", 0, "Seconds between two routing table snapshots, 0 disables the snapshots")
+	flag.IntVar(&config.CacheSize, "cacheSize", 0, "Number of fetched parcels a sampling node keeps and serves to other peers, 0 disables the cache")
+	flag.StringVar(&config.ReseedMode, "reseed", "off", "How validators re-seed the parcels they sample (off, put: write back into the store, provide: keep locally and announce a provider record)")
+	flag.BoolVar(&config.SampleFastPath, "sampleFastPath", false, "Ask the closest routing table peers for a parcel over the sample protocol before doing a DHT lookup")
+	flag.BoolVar(&config.TraceHolders, "traceHolders", true, "After a successful DHT GET, probe the lookup responders for the peer that holds the record, to record it in the operations CSV")
+	flag.BoolVar(&config.ChurnEnabled, "churn", false, "Make this node leave and re-join the network during the experiment")
+	flag.StringVar(&config.ChurnDistribution, "churnDist", "exponential", "Distribution of session and downtime lengths (exponential, uniform, fixed)")
+	flag.IntVar(&config.ChurnSessionMean, "churnSession", 60, "Mean time (in seconds) a churning node stays online")
+	flag.IntVar(&config.ChurnDowntimeMean, "churnDowntime", 30, "Mean time (in seconds) a churning node stays offline before re-joining")
+	flag.StringVar(&config.FaultSchedulePath, "faults", "", "Path to a JSON fault schedule (partition, drop, delay) to apply during the experiment")
+	flag.StringVar(&config.NetemConfigPath, "netem", "", "Path to a JSON latency/bandwidth emulation config (regions, latency matrix or table, jitter, bandwidth)")
 	flag.Parse()
 
+	// -seedMode was replaced by -store, keep old run scripts working. An
+	// explicit -store wins over it.
+	if config.SeedMode != "" {
+		if config.SeedMode != "dht" && config.SeedMode != "push" {
+			log.Fatalf("Unknown -seedMode %q, use -store instead\n", config.SeedMode)
+		}
+		storeSet := false
+		flag.Visit(func(f *flag.Flag) {
+			if f.Name == "store" {
+				storeSet = true
+			}
+		})
+		if storeSet {
+			log.Printf("-seedMode is deprecated, ignoring it in favour of -store %s\n", config.StoreType)
+		} else {
+			log.Printf("-seedMode is deprecated, running with -store %s\n", config.SeedMode)
+			config.StoreType = config.SeedMode
+		}
+	}
+
+	if config.ReseedMode != "off" && config.ReseedMode != "put" && config.ReseedMode != "provide" {
+		log.Fatalf("Unknown -reseed %q (off, put, provide)\n", config.ReseedMode)
+	}
+
+	if config.ChurnDistribution != "exponential" && config.ChurnDistribution != "uniform" && config.ChurnDistribution != "fixed" {
+		log.Fatalf("Unknown -churnDist %q (exponential, uniform, fixed)\n", config.ChurnDistribution)
+	}
+
+	if config.AcceleratedDHT != "off" && config.AcceleratedDHT != "builder" && config.AcceleratedDHT != "validators" {
+		log.Fatalf("Unknown -accelerated %q (off, builder, validators)\n", config.AcceleratedDHT)
+	}
+	if config.AcceleratedDHT != "off" && config.CrawlInterval <= 0 {
+		log.Fatal("-crawlInterval must be positive")
+	}
+
+	// The audit would re-seed blocks that retention already pruned.
+	if config.RetentionSlots > 0 && config.AuditWindow > config.RetentionSlots {
+		if config.AuditInterval > 0 {
+			log.Printf("-auditWindow %d is larger than -retention, auditing the %d retained blocks\n", config.AuditWindow, config.RetentionSlots)
+		}
+		config.AuditWindow = config.RetentionSlots
+	}
+
+	// The memory store is not shared between processes, so samplers would
+	// never find what the builder put.
+	if config.StoreType == "memory" {
+		log.Fatal("-store memory only works within one process, use dht, push or provider")
+	}
+
 	log.SetPrefix(config.NickFlag + ": ")
 	log.SetFlags(log.Lmicroseconds) //print time in microseconds
 	//ctx := context.Background()
@@ -112,12 +317,13 @@ func main() {
 		return
 	}
 
-	const builder_id = "12D3KooWE3AwZFT9zEWDUxhya62hmvEbRxYBWaosn7Kiqw5wsu73"
 	nodeType := strings.ToLower(config.NodeType)
 	nodeTypeSuffix := ""
 
 	if nodeType == "builder" {
 		nodeTypeSuffix = "B"
+	} else if nodeType == "bootstrap" {
+		nodeTypeSuffix = "S"
 	} else if nodeType == "validator" {
 		nodeTypeSuffix = "V"
 	} else {
@@ -147,15 +353,54 @@ func main() {
 
 	addr, _ := multiaddr.NewMultiaddr(fmt.Sprintf("/ip4/%s/tcp/%d", config.IP, config.Port))
 
+	var faultSchedule []FaultEvent
+	if config.FaultSchedulePath != "" {
+		faultSchedule, err = loadFaultSchedule(config.FaultSchedulePath)
+		if err != nil {
+			log.Fatal("Error loading fault schedule:", err)
+		}
+	}
+
+	gater := NewExperimentGater()
+	bandwidthCounter := metrics.NewBandwidthCounter()
+	faults := NewFaultInjector(gater)
+
 	h, err := libp2p.New(
 		libp2p.ListenAddrs(addr),
 		libp2p.Identity(priv),
+		libp2p.ConnectionGater(gater),
+		libp2p.BandwidthReporter(bandwidthCounter),
 	)
 	if err != nil {
 		log.Fatal(err)
 	}
+	gater.SetSelf(h.ID())
+	h = NewFaultyHost(h, faults)
+
+	if config.NetemConfigPath != "" {
+		netem, err := loadNetemConfig(config.NetemConfigPath)
+		if err != nil {
+			log.Fatal("Error loading netem config:", err)
+		}
+		h = NewShapedHost(h, netem)
+		log.Printf("\tEmulated region: %s\n", netem.Regions[netem.Region(h.ID())])
+	}
+
+	dstore, err := NewDatastore(config.DatastoreType, config.DatastorePath, h.ID())
+	if err != nil {
+		log.Fatal("Error opening datastore:", err)
+	}
+	defer dstore.Close()
+
+	stats.InitialStoredRecords, _, err = datastoreSize(context.Background(), dstore)
+	if err != nil {
+		log.Fatal("Error reading datastore:", err)
+	}
+	if stats.InitialStoredRecords > 0 {
+		log.Printf("\tReopened datastore with %d records\n", stats.InitialStoredRecords)
+	}
 
-	dht, err := NewDHT(context.Background(), h, nodeType)
+	dht, err := NewDHT(context.Background(), h, nodeType, dstore)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -177,67 +422,125 @@ func main() {
 	//routingDiscovery := discovery.NewRoutingDiscovery(dht)
 	//discovery.Advertise(context.Background(), routingDiscovery, "das")
 
-	// Register an event handler for peer connection
-	h.Network().Notify(&network.NotifyBundle{
-		ConnectedF: func(n network.Network, c network.Conn) {
-
-			if c.LocalPeer().String() == builder_id {
-				node_suffix := ""
-				if config.NodeType == "builder" {
-					node_suffix = "B"
-				} else if config.NodeType == "validator" {
-					node_suffix = "V"
-				} else {
-					node_suffix = "R"
-				}
-
-				remote_peer_id := c.RemotePeer()
-
-				routingTablePeerCountBefore := len(dht.RoutingTable().ListPeers())
-				dht.RoutingTable().TryAddPeer(remote_peer_id, false, false)
-				routingTablePeerCountAfter := len(dht.RoutingTable().ListPeers())
-
-				if routingTablePeerCountBefore == routingTablePeerCountAfter {
-					log.Printf("[%s - %s]: Failed to add peer %s to routing table\n", node_suffix, h.ID()[0:5], remote_peer_id[:5])
-				} else {
-					log.Printf(
-						"[%s - %s]: Peer %s connected to builder (%d -> %d connections)\n",
-						node_suffix,
-						h.ID()[0:5],
-						remote_peer_id[:5],
-						routingTablePeerCountBefore,
-						routingTablePeerCountAfter,
-					)
-				}
-
-			}
-		},
-	})
-
-	var wg sync.WaitGroup
 	ctx, cancel := context.WithCancel(context.Background())
 
-	if nodeType == "builder" {
+	// Join the header topic before connecting to anyone: gossipsub only keeps
+	// a connected peer if it already speaks the protocol when they connect,
+	// and a node only reports ready once it can receive headers.
+	pub, err := CreatePubSub(h, ctx)
+	if err != nil {
+		log.Fatal("Error creating pubSub:", err)
+	}
+
+	if len(config.DiscoveryPeers) == 0 {
 
-		log.Printf("[B - %s] Builder started: %s\n", h.ID()[:5], h.ID())
+		// Without bootstrap peers a node can only be the one the others
+		// bootstrap from.
+		if nodeType != "builder" && nodeType != "bootstrap" {
+			log.Fatalf("[%s - %s] No bootstrap peer given, pass at least one -peer\n", nodeTypeSuffix, h.ID()[:5])
+		}
+		log.Printf("[%s - %s] Started without bootstrap peers: %s\n", nodeTypeSuffix, h.ID()[:5], h.ID())
 
 	} else {
 
-		wg.Add(1)
-		go waitForBuilder(&wg, config.DiscoveryPeers, h, dht)
-		wg.Wait()
+		bootstrapCtx, bootstrapCancel := context.WithTimeout(ctx, time.Duration(config.BootstrapTimeout)*time.Second)
+		bootstrapResult, err := Bootstrap(bootstrapCtx, config.DiscoveryPeers, h, dht, nodeTypeSuffix)
+		bootstrapCancel()
+		stats.BootstrapAttempts = bootstrapResult.Attempts
+		stats.BootstrapDuration = bootstrapResult.Duration
+		if err != nil {
+			log.Printf("[%s - %s] Bootstrap failed after %d attempts in %.2f seconds: %s\n", nodeTypeSuffix, h.ID()[:5], bootstrapResult.Attempts, bootstrapResult.Duration.Seconds(), err.Error())
+			if filename, err := writeTotalStatsToFile(stats, h, nodeType); err != nil {
+				log.Println(err)
+			} else {
+				log.Printf("[%s - %s] Total Stats written to %s\n", nodeTypeSuffix, h.ID()[0:5], filename)
+			}
+			cancel()
+			dht.Close()
+			dstore.Close()
+			os.Exit(exitBootstrapFailed)
+		}
 
+		stats.ReadyAt = time.Now()
 		log.Printf("[%s - %s] Peer started: %s\n", nodeTypeSuffix, h.ID()[:5], h.ID()[:5])
 
 	}
 
-	service := NewService(h, protocol.ID(config.ProtocolID))
+	service := NewService(h, protocol.ID(config.ProtocolID), dstore)
 	err = service.SetupRPC()
 	if err != nil {
 		log.Fatal(err)
 	}
+	service.SetupSampleProtocol()
+
+	if nodeType == "validator" || nodeType == "nonvalidator" {
+		go service.ReportReady(ctx, bootstrapPeerIDs(config.DiscoveryPeers), nodeType, stats.ReadyAt, nodeTypeSuffix)
+	}
+
+	store, err := NewSampleStore(config.StoreType, service, dht)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// The builder has no peers yet, it starts its accelerated client once the
+	// network has joined (see StartMessaging).
+	if acceleratedFor(nodeType) && nodeType != "builder" && config.StoreType == "dht" {
+		frt, err := NewAcceleratedClient(ctx, h, dht, dstore)
+		if err != nil {
+			log.Printf("[%s - %s] Failed to start the accelerated DHT client, using the standard one: %s\n", nodeTypeSuffix, h.ID()[0:5], err.Error())
+		} else {
+			defer frt.Close()
+			store = NewDHTStore(frt)
+			log.Printf("[%s - %s] Accelerated DHT client ready with %d peers\n", nodeTypeSuffix, h.ID()[0:5], len(frt.Stat()))
+		}
+	}
+
+	if config.CacheSize > 0 && nodeType != "builder" && nodeType != "bootstrap" {
+		service.cache = NewParcelCache(config.CacheSize)
+		store = NewCachingStore(store, service.cache)
+	}
+
+	if config.ChurnEnabled && nodeType != "builder" && nodeType != "bootstrap" {
+		log.Printf("[%s - %s] Churn enabled (%s, %ds online, %ds offline)\n", nodeTypeSuffix, h.ID()[0:5], config.ChurnDistribution, config.ChurnSessionMean, config.ChurnDowntimeMean)
+		go StartChurn(ctx, h, dht, gater, stats, config.DiscoveryPeers, config.ChurnDistribution, config.ChurnSessionMean, config.ChurnDowntimeMean, nodeTypeSuffix, logger)
+	}
+
+	if config.SnapshotInterval > 0 {
+		go StartRoutingTableSnapshots(ctx, h, dht, nodeType, config.SnapshotInterval, nodeTypeSuffix)
+	}
+
+	storageReported := config.StorageInterval > 0 && configuredDHTMode(h.ID(), nodeType) != "client"
+	if storageReported {
+		go service.StartStorageReport(ctx, config.StorageInterval, stats, nodeTypeSuffix)
+	}
+
+	if len(faultSchedule) > 0 {
+		log.Printf("[%s - %s] Fault schedule loaded (%d faults)\n", nodeTypeSuffix, h.ID()[0:5], len(faultSchedule))
+		go StartFaultSchedule(ctx, h, faults, faultSchedule, stats, nodeTypeSuffix)
+	}
+
+	service.StartMessaging(h, dht, pub, store, stats, nodeType, config.ParcelSize, ctx, config.ExperimentDuration, logger)
+
+	// Stop the background tasks and the DHT before the datastore they write to
+	// is measured and closed.
+	cancel()
+	if err := dht.Close(); err != nil {
+		log.Printf("[%s - %s] Failed to close the DHT: %s\n", nodeTypeSuffix, h.ID()[0:5], err.Error())
+	}
 
-	service.StartMessaging(h, dht, stats, nodeType, config.ParcelSize, ctx, config.ExperimentDuration, logger)
+	bandwidthTotals := bandwidthCounter.GetBandwidthTotals()
+	stats.TotalBytesIn = bandwidthTotals.TotalIn
+	stats.TotalBytesOut = bandwidthTotals.TotalOut
+	service.mu.Lock()
+	stats.TotalServedParcels = service.servedParcels
+	service.mu.Unlock()
+	if service.cache != nil {
+		stats.CacheHits, stats.CacheMisses, stats.CacheEvictions, stats.CachedParcels = service.cache.Stats()
+	}
+	stats.StoredRecords, stats.StoredBytes, err = datastoreSize(context.Background(), dstore)
+	if err != nil {
+		log.Printf("[%s - %s] Failed to measure the datastore: %s\n", nodeTypeSuffix, h.ID()[0:5], err.Error())
+	}
 
 	if filename, err := writeOperationsToFile(stats, h, nodeType); err != nil {
 		log.Fatal(err)
@@ -257,7 +560,68 @@ func main() {
 		log.Printf("[%s - %s] Latencies written to %s\n", nodeTypeSuffix, h.ID()[0:5], filename)
 	}
 
-	cancel()
+	if filename, err := writeDHTConfigToFile(h, nodeType, dhtModeName(dht)); err != nil {
+		log.Fatal(err)
+	} else {
+		log.Printf("[%s - %s] DHT parameters written to %s\n", nodeTypeSuffix, h.ID()[0:5], filename)
+	}
+
+	if config.ChurnEnabled {
+		if filename, err := writeChurnEventsToFile(stats, h, nodeType); err != nil {
+			log.Fatal(err)
+		} else {
+			log.Printf("[%s - %s] Churn events written to %s\n", nodeTypeSuffix, h.ID()[0:5], filename)
+		}
+	}
+
+	if config.CustodyCount > 0 && nodeType != "builder" && nodeType != "bootstrap" {
+		if filename, err := writeCustodyToFile(stats, h, nodeType); err != nil {
+			log.Fatal(err)
+		} else {
+			log.Printf("[%s - %s] Custody written to %s\n", nodeTypeSuffix, h.ID()[0:5], filename)
+		}
+	}
+
+	if config.AuditInterval > 0 && nodeType == "builder" {
+		service.mu.Lock()
+		filename, err := writeDurabilityToFile(stats, h, nodeType)
+		service.mu.Unlock()
+		if err != nil {
+			log.Fatal(err)
+		} else {
+			log.Printf("[%s - %s] Durability audits written to %s\n", nodeTypeSuffix, h.ID()[0:5], filename)
+		}
+	}
+
+	if storageReported {
+		service.mu.Lock()
+		filename, err := writeStorageToFile(stats, h, nodeType)
+		service.mu.Unlock()
+		if err != nil {
+			log.Fatal(err)
+		} else {
+			log.Printf("[%s - %s] Storage load written to %s\n", nodeTypeSuffix, h.ID()[0:5], filename)
+		}
+	}
+
+	if readyReports := service.readyReports(); len(readyReports) > 0 {
+		if filename, err := writeReadinessToFile(readyReports, h, nodeType); err != nil {
+			log.Fatal(err)
+		} else {
+			log.Printf("[%s - %s] Ready reports written to %s\n", nodeTypeSuffix, h.ID()[0:5], filename)
+		}
+	}
+
+	if len(faultSchedule) > 0 {
+		faults.mu.Lock()
+		filename, err := writeFaultTimelineToFile(stats, h, nodeType)
+		faults.mu.Unlock()
+		if err != nil {
+			log.Fatal(err)
+		} else {
+			log.Printf("[%s - %s] Fault timeline written to %s\n", nodeTypeSuffix, h.ID()[0:5], filename)
+		}
+	}
 
 }
 
@@ -295,10 +659,10 @@ func writeTotalStatsToFile(stats *Stats, h host.Host, nodeType string) (string,
 	w := csv.NewWriter(f)
 	defer w.Flush()
 
-	headers := []string{"Total PUT messages", "Total failed PUTs", "Total successful PUTs", "Total GET messages", "Total failed GETs", "Total successful GETs"}
+	headers := []string{"Total PUT messages", "Total failed PUTs", "Total successful PUTs", "Total GET messages", "Total failed GETs", "Total successful GETs", "Batched GET requests", "Batched parcels", "Requests saved", "Batch lookups", "Lookups saved", "Batch lookup queries", "Reseeded parcels", "Failed reseeds", "Served parcels", "Cache hits", "Cache misses", "Cache evictions", "Cached parcels", "Initial stored records", "Stored records", "Stored bytes", "Pruned records", "Pruned bytes", "Bytes in", "Bytes out", "Bootstrap attempts", "Bootstrap duration (s)", "Ready at", "Production started at", "Ready nodes at start"}
 
 	rows := [][]string{
-		{strconv.Itoa(stats.TotalPutMessages), strconv.Itoa(stats.TotalFailedPuts), strconv.Itoa(stats.TotalSuccessPuts), strconv.Itoa(stats.TotalGetMessages), strconv.Itoa(stats.TotalFailedGets), strconv.Itoa(stats.TotalSuccessGets)},
+		{strconv.Itoa(stats.TotalPutMessages), strconv.Itoa(stats.TotalFailedPuts), strconv.Itoa(stats.TotalSuccessPuts), strconv.Itoa(stats.TotalGetMessages), strconv.Itoa(stats.TotalFailedGets), strconv.Itoa(stats.TotalSuccessGets), strconv.Itoa(stats.BatchRequests), strconv.Itoa(stats.BatchedParcels), strconv.Itoa(stats.RequestsSaved), strconv.Itoa(stats.BatchLookups), strconv.Itoa(stats.LookupsSaved), strconv.Itoa(stats.BatchLookupQueries), strconv.Itoa(stats.TotalReseededParcels), strconv.Itoa(stats.TotalFailedReseeds), strconv.Itoa(stats.TotalServedParcels), strconv.Itoa(stats.CacheHits), strconv.Itoa(stats.CacheMisses), strconv.Itoa(stats.CacheEvictions), strconv.Itoa(stats.CachedParcels), strconv.Itoa(stats.InitialStoredRecords), strconv.Itoa(stats.StoredRecords), strconv.FormatInt(stats.StoredBytes, 10), strconv.Itoa(stats.TotalPrunedRecords), strconv.FormatInt(stats.TotalPrunedBytes, 10), strconv.FormatInt(stats.TotalBytesIn, 10), strconv.FormatInt(stats.TotalBytesOut, 10), strconv.Itoa(stats.BootstrapAttempts), strconv.FormatFloat(stats.BootstrapDuration.Seconds(), 'f', 2, 64), formatOptionalTime(stats.ReadyAt), formatOptionalTime(stats.ProductionStartedAt), strconv.Itoa(stats.ReadyNodesAtStart)},
 	}
 
 	// Write headers and rows to CSV file
@@ -316,7 +680,7 @@ func writeOperationsToFile(stats *Stats, h host.Host, nodeType string) (string,
 
 	// Convert latencies and hops to rows
 	var operationRows [][]string
-	for i := 0; i < len(stats.BlockIDs) || i < len(stats.ParcelKeyHashes) || i < len(stats.ParcelStatuses) || i < len(stats.ParcelDataLengths) || i < len(stats.PutTimestamps) || i < len(stats.GetTimestamps) || i < len(stats.GetHops) || i < len(stats.PutLatencies) || i < len(stats.GetLatencies); i++ {
+	for i := 0; i < len(stats.BlockIDs) || i < len(stats.ParcelKeyHashes) || i < len(stats.ParcelStatuses) || i < len(stats.ParcelDataLengths) || i < len(stats.PutTimestamps) || i < len(stats.GetTimestamps) || i < len(stats.GetHops) || i < len(stats.GetMethods) || i < len(stats.Routes) || i < len(stats.PutLatencies) || i < len(stats.GetLatencies); i++ {
 		var row []string
 
 		if i < len(stats.BlockIDs) {
@@ -373,6 +737,18 @@ func writeOperationsToFile(stats *Stats, h host.Host, nodeType string) (string,
 			row = append(row, "")
 		}
 
+		if i < len(stats.GetMethods) {
+			row = append(row, stats.GetMethods[i])
+		} else {
+			row = append(row, "")
+		}
+
+		if i < len(stats.Routes) {
+			row = append(row, stats.Routes[i].columns(h.ID())...)
+		} else {
+			row = append(row, RouteInfo{}.columns(h.ID())...)
+		}
+
 		operationRows = append(operationRows, row)
 	}
 
@@ -386,7 +762,7 @@ func writeOperationsToFile(stats *Stats, h host.Host, nodeType string) (string,
 	w := csv.NewWriter(f)
 	defer w.Flush()
 
-	headers := []string{"Block ID", "Parcel Key Hashes", "Parcel Status", "Parcel Data Length (Bytes)", "PUT timestamps", "PUT latencies", "GET timestamps", "GET latencies", "GET hops"}
+	headers := []string{"Block ID", "Parcel Key Hashes", "Parcel Status", "Parcel Data Length (Bytes)", "PUT timestamps", "PUT latencies", "GET timestamps", "GET latencies", "GET hops", "GET method", "Record Key", "Route Peers", "Route Holders", "Peer-Key CPL", "Peer-Key XOR Distance", "Peer-Requester CPL", "Peer-Requester XOR Distance"}
 	rows := operationRows
 
 	// Write headers and rows to CSV file
@@ -464,6 +840,270 @@ func writeLatencyStatsToFile(stats *Stats, h host.Host, nodeType string) (string
 	return filename, nil
 }
 
+func writeDHTConfigToFile(h host.Host, nodeType string, dhtMode string) (string, error) {
+	filename := config.LogDirectory + h.ID().String()[0:10] + "_dhtconfig_" + nodeType + ".csv"
+
+	f, err := os.Create(filename)
+	if err != nil {
+		return filename, err
+	}
+	defer f.Close()
+
+	w := csv.NewWriter(f)
+	defer w.Flush()
+
+	headers := []string{"Bucket size", "Alpha", "Beta", "Refresh interval (s)", "Refresh query timeout (s)", "Query timeout (s)", "Accelerated client", "Crawl interval (s)", "Configured mode", "Mode at end"}
+	rows := [][]string{
+		{strconv.Itoa(config.DHTBucketSize), strconv.Itoa(config.DHTAlpha), strconv.Itoa(config.DHTBeta), strconv.Itoa(config.DHTRefreshInterval), strconv.Itoa(config.DHTRefreshQueryTimeout), strconv.Itoa(config.DHTQueryTimeout), strconv.FormatBool(acceleratedFor(nodeType) && config.StoreType == "dht"), strconv.Itoa(config.CrawlInterval), configuredDHTMode(h.ID(), nodeType), dhtMode},
+	}
+
+	// Write headers and rows to CSV file
+	w.Write(headers)
+	w.WriteAll(rows)
+	if err := w.Error(); err != nil {
+		return filename, err
+	}
+
+	return filename, nil
+}
+
+func writeChurnEventsToFile(stats *Stats, h host.Host, nodeType string) (string, error) {
+	filename := config.LogDirectory + h.ID().String()[0:10] + "_churn_" + nodeType + ".csv"
+
+	var churnRows [][]string
+	for i := 0; i < len(stats.ChurnEvents); i++ {
+		churnRows = append(churnRows, []string{
+			stats.ChurnEvents[i],
+			stats.ChurnTimestamps[i].String(),
+			strconv.FormatInt(stats.ChurnDurations[i].Microseconds(), 10),
+		})
+	}
+
+	f, err := os.Create(filename)
+	if err != nil {
+		return filename, err
+	}
+	defer f.Close()
+
+	w := csv.NewWriter(f)
+	defer w.Flush()
+
+	headers := []string{"Event", "Timestamp", "Previous State Duration (us)"}
+	rows := churnRows
+
+	// Write headers and rows to CSV file
+	w.Write(headers)
+	w.WriteAll(rows)
+	if err := w.Error(); err != nil {
+		return filename, err
+	}
+
+	return filename, nil
+}
+
+func writeCustodyToFile(stats *Stats, h host.Host, nodeType string) (string, error) {
+	filename := config.LogDirectory + h.ID().String()[0:10] + "_custody_" + nodeType + ".csv"
+
+	joinIndices := func(indices []int) string {
+		strs := make([]string, len(indices))
+		for i, index := range indices {
+			strs[i] = strconv.Itoa(index)
+		}
+		return strings.Join(strs, " ")
+	}
+
+	var custodyRows [][]string
+	for i := 0; i < len(stats.CustodyBlockIDs); i++ {
+		custodyRows = append(custodyRows, []string{
+			strconv.Itoa(stats.CustodyBlockIDs[i]),
+			joinIndices(stats.CustodyRows[i]),
+			joinIndices(stats.CustodyCols[i]),
+			strconv.Itoa(stats.CustodyAssignedParcels[i]),
+			strconv.Itoa(stats.CustodyAcquiredParcels[i]),
+			strconv.FormatInt(stats.CustodyLatencies[i].Microseconds(), 10),
+		})
+	}
+
+	f, err := os.Create(filename)
+	if err != nil {
+		return filename, err
+	}
+	defer f.Close()
+
+	w := csv.NewWriter(f)
+	defer w.Flush()
+
+	headers := []string{"Block ID", "Custody Rows", "Custody Cols", "Assigned Parcels", "Acquired Parcels", "Acquisition Latency (us)"}
+	rows := custodyRows
+
+	// Write headers and rows to CSV file
+	w.Write(headers)
+	w.WriteAll(rows)
+	if err := w.Error(); err != nil {
+		return filename, err
+	}
+
+	return filename, nil
+}
+
+func writeFaultTimelineToFile(stats *Stats, h host.Host, nodeType string) (string, error) {
+	filename := config.LogDirectory + h.ID().String()[0:10] + "_faults_" + nodeType + ".csv"
+
+	var faultRows [][]string
+	for i := 0; i < len(stats.FaultTypes); i++ {
+		faultEnd := ""
+		if !stats.FaultEndTimestamps[i].IsZero() {
+			faultEnd = stats.FaultEndTimestamps[i].String()
+		}
+		faultRows = append(faultRows, []string{
+			stats.FaultTypes[i],
+			stats.FaultParameters[i],
+			stats.FaultStartTimestamps[i].String(),
+			faultEnd,
+			strconv.Itoa(stats.FaultDroppedStreams[i]),
+		})
+	}
+
+	f, err := os.Create(filename)
+	if err != nil {
+		return filename, err
+	}
+	defer f.Close()
+
+	w := csv.NewWriter(f)
+	defer w.Flush()
+
+	headers := []string{"Fault Type", "Parameters", "Start timestamp", "End timestamp", "Dropped Streams"}
+	rows := faultRows
+
+	// Write headers and rows to CSV file
+	w.Write(headers)
+	w.WriteAll(rows)
+	if err := w.Error(); err != nil {
+		return filename, err
+	}
+
+	return filename, nil
+}
+
+func writeDurabilityToFile(stats *Stats, h host.Host, nodeType string) (string, error) {
+	filename := config.LogDirectory + h.ID().String()[0:10] + "_durability_" + nodeType + ".csv"
+
+	var durabilityRows [][]string
+	for i := 0; i < len(stats.DurabilityBlockIDs); i++ {
+		durabilityRows = append(durabilityRows, []string{
+			stats.DurabilityTimestamps[i].String(),
+			strconv.Itoa(stats.DurabilityBlockIDs[i]),
+			strconv.FormatFloat(stats.DurabilityBlockAges[i].Seconds(), 'f', 2, 64),
+			strconv.Itoa(stats.DurabilityAuditedParcels[i]),
+			strconv.Itoa(stats.DurabilityRetrievableParcels[i]),
+			strconv.Itoa(stats.DurabilityHolders[i]),
+			strconv.Itoa(stats.DurabilityRepairedParcels[i]),
+		})
+	}
+
+	f, err := os.Create(filename)
+	if err != nil {
+		return filename, err
+	}
+	defer f.Close()
+
+	w := csv.NewWriter(f)
+	defer w.Flush()
+
+	headers := []string{"Timestamp", "Block ID", "Block Age (s)", "Audited Parcels", "Retrievable Parcels", "Holders", "Repaired Parcels"}
+	rows := durabilityRows
+
+	// Write headers and rows to CSV file
+	w.Write(headers)
+	w.WriteAll(rows)
+	if err := w.Error(); err != nil {
+		return filename, err
+	}
+
+	return filename, nil
+}
+
+func writeStorageToFile(stats *Stats, h host.Host, nodeType string) (string, error) {
+	filename := config.LogDirectory + h.ID().String()[0:10] + "_storage_" + nodeType + ".csv"
+
+	var storageRows [][]string
+	for i := 0; i < len(stats.StorageBlockIDs); i++ {
+		storageRows = append(storageRows, []string{
+			stats.StorageTimestamps[i].String(),
+			strconv.Itoa(stats.StorageBlockIDs[i]),
+			strconv.Itoa(stats.StorageRecords[i]),
+			strconv.FormatInt(stats.StorageBytes[i], 10),
+		})
+	}
+
+	f, err := os.Create(filename)
+	if err != nil {
+		return filename, err
+	}
+	defer f.Close()
+
+	w := csv.NewWriter(f)
+	defer w.Flush()
+
+	headers := []string{"Timestamp", "Block ID", "Records", "Bytes"}
+	rows := storageRows
+
+	// Write headers and rows to CSV file
+	w.Write(headers)
+	w.WriteAll(rows)
+	if err := w.Error(); err != nil {
+		return filename, err
+	}
+
+	return filename, nil
+}
+
+// writeReadinessToFile writes the ready reports a coordinator received, or
+// that the builder collected before producing blocks.
+func writeReadinessToFile(reports []ReadyReport, h host.Host, nodeType string) (string, error) {
+	filename := config.LogDirectory + h.ID().String()[0:10] + "_readiness_" + nodeType + ".csv"
+
+	var readinessRows [][]string
+	for _, report := range reports {
+		readinessRows = append(readinessRows, []string{
+			report.Peer,
+			report.NodeType,
+			report.ReadyAt.String(),
+			report.ReceivedAt.String(),
+		})
+	}
+
+	f, err := os.Create(filename)
+	if err != nil {
+		return filename, err
+	}
+	defer f.Close()
+
+	w := csv.NewWriter(f)
+	defer w.Flush()
+
+	headers := []string{"Peer", "Node type", "Ready at", "Received at"}
+	rows := readinessRows
+
+	// Write headers and rows to CSV file
+	w.Write(headers)
+	w.WriteAll(rows)
+	if err := w.Error(); err != nil {
+		return filename, err
+	}
+
+	return filename, nil
+}
+
+// formatOptionalTime leaves a time that was never set empty in the CSV.
+func formatOptionalTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.String()
+}
+
 type addrList []multiaddr.Multiaddr
 
 func (al *addrList) String() string {
@@ -475,55 +1115,12 @@ func (al *addrList) String() string {
 }
 
 func (al *addrList) Set(value string) error {
-	addr, err := multiaddr.NewMultiaddr(value)
-	if err != nil {
-		return err
+	for _, v := range strings.Split(value, ",") {
+		addr, err := multiaddr.NewMultiaddr(strings.TrimSpace(v))
+		if err != nil {
+			return err
+		}
+		*al = append(*al, addr)
 	}
-	*al = append(*al, addr)
 	return nil
 }
-
-func waitForBuilder(wg *sync.WaitGroup, discoveryPeers addrList, h host.Host, dht *dht.IpfsDHT) {
-	defer wg.Done()
-
-	// Wait for a couple of seconds to make sure bootstrap peer is up and running
-    randMath.Seed(time.Now().UnixNano())
-    sleepDuration := time.Duration(randMath.Intn(31)) * time.Second
-    log.Printf("Sleeping for %d seconds...", sleepDuration/time.Second)
-
-    // Sleep for the random duration
-    time.Sleep(sleepDuration)
-
-	// Connect to bootstrap peers
-    for {
-	    for _, peerAddr := range discoveryPeers {
-	        ctx := context.Background()
-		    peerinfo, _ := peer.AddrInfoFromP2pAddr(peerAddr)
-
-		    if err := h.Connect(ctx, *peerinfo); err != nil {
-
-			    log.Print()
-			    log.Printf("Error connecting to bootstrap node %q: %-v", peerinfo, err)
-			    log.Printf("peerinfo: %s\n", peerinfo)
-			    log.Printf("peerinfo.ID: %s\n", peerinfo.ID)
-			    log.Printf("peerinfo.Addrs: %s\n", peerinfo.Addrs)
-			    log.Printf("err: %s\n", err)
-			    log.Print()
-
-		    } else {
-			    log.Printf("Successfully connected to bootstrap node %q ", peerinfo)
-			    if _, err := dht.FindPeer(ctx, peerinfo.ID); err != nil {
-				    log.Printf("Error finding peer: %s\n", err)
-			    } else {
-				    log.Printf("Bootstrapping is successful")
-                    return;
-			    }
-		    }
-            // Sleep for one second and re-try connecting afterwards...
-	        time.Sleep(1 * time.Second)
-	    }
-    }
-
-	log.Printf("Could not connect to any bootstrap nodes...")
-
-}
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+)
+
+// NetemConfig describes the emulated network given with -netem. Every peer is
+// placed in one of Regions (by hashing its peer ID unless it is listed in
+// PeerRegions), and the one-way latency between two peers is looked up either
+// in LatencyTable (region name to region name) or in the LatencyMs matrix,
+// indexed in the order of Regions.
+type NetemConfig struct {
+	Regions       []string                  `json:"regions"`
+	PeerRegions   map[string]string         `json:"peerRegions"`
+	LatencyMs     [][]int                   `json:"latencyMs"`
+	LatencyTable  map[string]map[string]int `json:"latencyTable"`
+	JitterMs      int                       `json:"jitterMs"`
+	BandwidthKbps int                       `json:"bandwidthKbps"`
+}
+
+func loadNetemConfig(path string) (*NetemConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	netem := &NetemConfig{}
+	if err := json.Unmarshal(data, netem); err != nil {
+		return nil, err
+	}
+
+	if len(netem.Regions) == 0 {
+		return nil, fmt.Errorf("netem config has no regions")
+	}
+	if netem.LatencyTable != nil {
+		for _, from := range netem.Regions {
+			for _, to := range netem.Regions {
+				if _, ok := netem.LatencyTable[from][to]; !ok {
+					return nil, fmt.Errorf("latency table has no entry from %q to %q", from, to)
+				}
+			}
+		}
+	} else {
+		if len(netem.LatencyMs) != len(netem.Regions) {
+			return nil, fmt.Errorf("latency matrix has %d rows for %d regions", len(netem.LatencyMs), len(netem.Regions))
+		}
+		for _, row := range netem.LatencyMs {
+			if len(row) != len(netem.Regions) {
+				return nil, fmt.Errorf("latency matrix row has %d columns for %d regions", len(row), len(netem.Regions))
+			}
+		}
+	}
+
+	for p, name := range netem.PeerRegions {
+		if netem.regionIndex(name) < 0 {
+			return nil, fmt.Errorf("peer %s is placed in unknown region %q", p, name)
+		}
+	}
+
+	return netem, nil
+}
+
+func (n *NetemConfig) regionIndex(name string) int {
+	for i, region := range n.Regions {
+		if region == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// Region returns the region of p. Every node computes the same region for the
+// same peer.
+func (n *NetemConfig) Region(p peer.ID) int {
+	if name, ok := n.PeerRegions[p.String()]; ok {
+		return n.regionIndex(name)
+	}
+
+	hash := sha256.Sum256([]byte(p))
+	return int(binary.BigEndian.Uint64(hash[0:8]) % uint64(len(n.Regions)))
+}
+
+// Latency returns the one-way latency between two peers, without jitter.
+func (n *NetemConfig) Latency(from peer.ID, to peer.ID) time.Duration {
+	fromRegion := n.Region(from)
+	toRegion := n.Region(to)
+
+	if n.LatencyTable != nil {
+		return time.Duration(n.LatencyTable[n.Regions[fromRegion]][n.Regions[toRegion]]) * time.Millisecond
+	}
+	return time.Duration(n.LatencyMs[fromRegion][toRegion]) * time.Millisecond
+}
+
+// link is the emulated uplink from this node to one remote peer. The bandwidth
+// cap is shared by every stream to that peer, and bytes reach the peer in the
+// order they were sent on any of them.
+type link struct {
+	mu            sync.Mutex
+	nextFree      time.Time
+	lastDeliverAt time.Time
+}
+
+// reserve books the link for n bytes and returns how long the caller has to
+// wait until they are transmitted.
+func (l *link) reserve(n int, bandwidthKbps int) time.Duration {
+	if bandwidthKbps <= 0 {
+		return 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if l.nextFree.Before(now) {
+		l.nextFree = now
+	}
+	l.nextFree = l.nextFree.Add(time.Duration(float64(n*8) / float64(bandwidthKbps*1000) * float64(time.Second)))
+	return l.nextFree.Sub(now)
+}
+
+// deliverAt returns when bytes that finished transmitting now reach the peer:
+// after the propagation delay, and not before bytes sent earlier on the link.
+func (l *link) deliverAt(delay time.Duration) time.Time {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	at := time.Now().Add(delay)
+	if at.Before(l.lastDeliverAt) {
+		at = l.lastDeliverAt
+	}
+	l.lastDeliverAt = at
+	return at
+}
+
+// shapedHost wraps the libp2p host so that every stream opened or accepted by
+// the DHT, pubsub and RPC is delayed according to the emulated network.
+type shapedHost struct {
+	host.Host
+	netem *NetemConfig
+
+	mu     sync.Mutex
+	links  map[peer.ID]*link
+	random *rand.Rand
+}
+
+func NewShapedHost(h host.Host, netem *NetemConfig) host.Host {
+	return &shapedHost{
+		Host:   h,
+		netem:  netem,
+		links:  make(map[peer.ID]*link),
+		random: rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+func (h *shapedHost) link(p peer.ID) *link {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	l, ok := h.links[p]
+	if !ok {
+		l = &link{}
+		h.links[p] = l
+	}
+	return l
+}
+
+func (h *shapedHost) jitter() time.Duration {
+	if h.netem.JitterMs <= 0 {
+		return 0
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return time.Duration(h.random.Intn(2*h.netem.JitterMs+1)-h.netem.JitterMs) * time.Millisecond
+}
+
+func (h *shapedHost) shape(s network.Stream) network.Stream {
+	return &shapedStream{Stream: s, host: h, link: h.link(s.Conn().RemotePeer())}
+}
+
+func (h *shapedHost) NewStream(ctx context.Context, p peer.ID, pids ...protocol.ID) (network.Stream, error) {
+	s, err := h.Host.NewStream(ctx, p, pids...)
+	if err != nil {
+		return nil, err
+	}
+	return h.shape(s), nil
+}
+
+func (h *shapedHost) SetStreamHandler(pid protocol.ID, handler network.StreamHandler) {
+	h.Host.SetStreamHandler(pid, func(s network.Stream) {
+		handler(h.shape(s))
+	})
+}
+
+func (h *shapedHost) SetStreamHandlerMatch(pid protocol.ID, match func(protocol.ID) bool, handler network.StreamHandler) {
+	h.Host.SetStreamHandlerMatch(pid, match, func(s network.Stream) {
+		handler(h.shape(s))
+	})
+}
+
+// Number of writes a shaped stream holds back before Write blocks.
+const shapedStreamQueue = 1024
+
+var errShapedStreamClosed = errors.New("shaped stream closed for writing")
+
+type pendingWrite struct {
+	data      []byte
+	deliverAt time.Time
+}
+
+// shapedStream emulates the link to the remote peer on writes. The writer
+// only waits for its bytes to be transmitted on the capped link; the latency
+// and jitter are applied by a delivery goroutine, so writes in flight overlap
+// and a message split over several writes pays the latency once. Each side
+// only delays its own writes, so a request/response pays the latency once per
+// direction.
+type shapedStream struct {
+	network.Stream
+	host *shapedHost
+	link *link
+
+	mu     sync.Mutex
+	queue  chan pendingWrite
+	done   chan struct{}
+	closed bool
+
+	errMu sync.Mutex
+	err   error
+}
+
+func (s *shapedStream) Write(b []byte) (int, error) {
+	if wait := s.link.reserve(len(b), s.host.netem.BandwidthKbps); wait > 0 {
+		time.Sleep(wait)
+	}
+	deliverAt := s.link.deliverAt(s.host.netem.Latency(s.host.ID(), s.Conn().RemotePeer()) + s.host.jitter())
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return 0, errShapedStreamClosed
+	}
+	if err := s.deliveryErr(); err != nil {
+		return 0, err
+	}
+	if s.queue == nil {
+		s.queue = make(chan pendingWrite, shapedStreamQueue)
+		s.done = make(chan struct{})
+		go s.deliver()
+	}
+
+	// The caller may reuse b once Write returns.
+	data := make([]byte, len(b))
+	copy(data, b)
+	s.queue <- pendingWrite{data: data, deliverAt: deliverAt}
+	return len(b), nil
+}
+
+// deliver writes the queued bytes to the underlying stream once they are due.
+// After a failed write the rest of the queue is dropped and the error is
+// returned by the next Write.
+func (s *shapedStream) deliver() {
+	defer close(s.done)
+
+	for w := range s.queue {
+		if s.deliveryErr() != nil {
+			continue
+		}
+		if wait := time.Until(w.deliverAt); wait > 0 {
+			time.Sleep(wait)
+		}
+		if _, err := s.Stream.Write(w.data); err != nil {
+			s.errMu.Lock()
+			s.err = err
+			s.errMu.Unlock()
+		}
+	}
+}
+
+func (s *shapedStream) deliveryErr() error {
+	s.errMu.Lock()
+	defer s.errMu.Unlock()
+	return s.err
+}
+
+// flush stops accepting writes and waits until the queued ones are delivered.
+func (s *shapedStream) flush() {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	s.closed = true
+	queue, done := s.queue, s.done
+	s.mu.Unlock()
+
+	if queue != nil {
+		close(queue)
+		<-done
+	}
+}
+
+func (s *shapedStream) CloseWrite() error {
+	s.flush()
+	return s.Stream.CloseWrite()
+}
+
+func (s *shapedStream) Close() error {
+	s.flush()
+	return s.Stream.Close()
+}
+
+func (s *shapedStream) Reset() error {
+	err := s.Stream.Reset()
+	s.flush()
+	return err
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"strings"
+
+	ds "github.com/ipfs/go-datastore"
+	dsq "github.com/ipfs/go-datastore/query"
+	"github.com/multiformats/go-base32"
+)
+
+// parcelBlockID returns the block of the /das/sample/<block>/... record a DHT
+// datastore key holds, or false if the key holds something else (provider
+// records, other namespaces).
+func parcelBlockID(key ds.Key) (int, bool) {
+	decoded, err := base32.RawStdEncoding.DecodeString(strings.TrimPrefix(key.String(), "/"))
+	if err != nil {
+		return 0, false
+	}
+	return parcelKeyBlockID(string(decoded))
+}
+
+// parcelKeyBlockID returns the block of a /das/sample/<block>/... parcel key.
+func parcelKeyBlockID(key string) (int, bool) {
+	parts := strings.Split(key, "/")
+	if len(parts) < 4 || parts[1] != "das" || parts[2] != "sample" {
+		return 0, false
+	}
+
+	blockID, err := strconv.Atoi(parts[3])
+	if err != nil {
+		return 0, false
+	}
+	return blockID, true
+}
+
+// PruneBlocks deletes the parcels of every block more than retentionSlots
+// blocks older than currentBlockID from the datastore and from the parcel
+// cache, and forgets their remembered holders, so long experiments do not
+// keep every block in memory.
+func (s *Service) PruneBlocks(ctx context.Context, currentBlockID int, retentionSlots int, stats *Stats, nodeTypeSuffix string) {
+	oldestKept := currentBlockID - retentionSlots + 1
+
+	prunedCached := 0
+	if s.cache != nil {
+		prunedCached = s.cache.RemoveBlocksBefore(oldestKept)
+	}
+	s.forgetHolders(oldestKept)
+
+	// Parcel records are stored under the base32 encoding of their DHT key, a
+	// single key segment, so no query prefix selects them: every key is
+	// listed, without its value, and filtered by parcelBlockID. Both
+	// datastores let keys be deleted while a query is open.
+	results, err := s.datastore.Query(ctx, dsq.Query{KeysOnly: true, ReturnsSizes: true})
+	if err != nil {
+		log.Printf("[%s - %s] Failed to list the datastore for pruning: %s\n", nodeTypeSuffix, s.host.ID()[0:5], err.Error())
+		return
+	}
+	defer results.Close()
+
+	prunedRecords := 0
+	prunedBytes := 0
+	for result := range results.Next() {
+		if result.Error != nil {
+			log.Printf("[%s - %s] Failed to list the datastore for pruning: %s\n", nodeTypeSuffix, s.host.ID()[0:5], result.Error.Error())
+			break
+		}
+
+		key := ds.NewKey(result.Key)
+		blockID, ok := parcelBlockID(key)
+		if !ok || blockID >= oldestKept {
+			continue
+		}
+
+		if err := s.datastore.Delete(ctx, key); err != nil {
+			log.Printf("[%s - %s] Failed to prune %s: %s\n", nodeTypeSuffix, s.host.ID()[0:5], key, err.Error())
+			continue
+		}
+		prunedRecords++
+		prunedBytes += result.Size
+	}
+
+	if prunedRecords == 0 && prunedCached == 0 {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats.TotalPrunedRecords += prunedRecords
+	stats.TotalPrunedBytes += int64(prunedBytes)
+
+	log.Printf("[%s - %s] Pruned %d records (%d bytes) and %d cached parcels older than block %d.\n", nodeTypeSuffix, s.host.ID()[0:5], prunedRecords, prunedBytes, prunedCached, oldestKept)
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"log"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// How often a node retries its ready report and the builder polls the
+// coordinators, and how long one such call may take.
+const (
+	readyPollInterval = time.Second
+	readyCallTimeout  = 5 * time.Second
+)
+
+// bootstrapPeerIDs returns the peer IDs of the -peer multiaddresses. These
+// are the coordinators nodes report ready to.
+func bootstrapPeerIDs(peers addrList) []peer.ID {
+	var ids []peer.ID
+	for _, addr := range peers {
+		info, err := peer.AddrInfoFromP2pAddr(addr)
+		if err == nil {
+			ids = append(ids, info.ID)
+		}
+	}
+	return ids
+}
+
+// recordReady keeps the first ready report of every node.
+func (s *Service) recordReady(report ReadyReport) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.readyNodes == nil {
+		s.readyNodes = make(map[string]ReadyReport)
+	}
+	if _, ok := s.readyNodes[report.Peer]; !ok {
+		s.readyNodes[report.Peer] = report
+	}
+}
+
+// readyReports returns the ready reports this node holds, oldest first.
+func (s *Service) readyReports() []ReadyReport {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	reports := make([]ReadyReport, 0, len(s.readyNodes))
+	for _, report := range s.readyNodes {
+		reports = append(reports, report)
+	}
+	sort.Slice(reports, func(i, j int) bool {
+		return reports[i].ReadyAt.Before(reports[j].ReadyAt)
+	})
+	return reports
+}
+
+// ReportReady tells every coordinator that this node is ready, retrying each
+// of them until it answers or ctx is done.
+func (s *Service) ReportReady(ctx context.Context, coordinators []peer.ID, nodeType string, readyAt time.Time, nodeTypeSuffix string) {
+	report := ReadyReport{Peer: s.host.ID().String(), NodeType: nodeType, ReadyAt: readyAt}
+
+	var reportWg sync.WaitGroup
+	for _, coordinator := range coordinators {
+		reportWg.Add(1)
+		go func(coordinator peer.ID) {
+			defer reportWg.Done()
+
+			for {
+				callCtx, cancel := context.WithTimeout(ctx, readyCallTimeout)
+				err := s.rpcClient.CallContext(callCtx, coordinator, ReadyService, ReadyServiceFuncReportReady, report, &ReadyReply{})
+				cancel()
+				if err == nil {
+					log.Printf("[%s - %s] Reported ready to %s\n", nodeTypeSuffix, s.host.ID()[0:5], coordinator.String()[0:5])
+					return
+				}
+
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(readyPollInterval):
+				}
+			}
+		}(coordinator)
+	}
+	reportWg.Wait()
+}
+
+// WaitForReady blocks the builder until fraction of the expected nodes have
+// reported ready, to the builder itself or to one of the coordinators, and
+// the builder has a peer to seed to. Past timeout it stops waiting for the
+// reports; with no expected nodes it waits the whole timeout. It returns the
+// number of ready nodes.
+func (s *Service) WaitForReady(ctx context.Context, kdht *dht.IpfsDHT, coordinators []peer.ID, expected int, fraction float64, timeout time.Duration) int {
+	needed := int(math.Ceil(fraction * float64(expected)))
+	deadline := time.Now().Add(timeout)
+
+	pollTicker := time.NewTicker(readyPollInterval)
+	defer pollTicker.Stop()
+
+	lastReady := -1
+	for {
+		for _, coordinator := range coordinators {
+			var reply ReadyReply
+			callCtx, cancel := context.WithTimeout(ctx, readyCallTimeout)
+			err := s.rpcClient.CallContext(callCtx, coordinator, ReadyService, ReadyServiceFuncReadyNodes, struct{}{}, &reply)
+			cancel()
+			if err == nil {
+				for _, report := range reply.Reports {
+					s.recordReady(report)
+				}
+			}
+		}
+
+		ready := len(s.readyReports())
+		if ready != lastReady {
+			log.Printf("[B - %s] %d/%d nodes ready (%d needed)\n", s.host.ID()[0:5], ready, expected, needed)
+			lastReady = ready
+		}
+
+		if len(kdht.RoutingTable().ListPeers()) > 0 {
+			if expected > 0 && ready >= needed {
+				log.Printf("[B - %s] Enough nodes ready, starting block production\n", s.host.ID()[0:5])
+				return ready
+			}
+			if time.Now().After(deadline) {
+				log.Printf("[B - %s] Readiness timeout, starting block production with %d/%d nodes ready\n", s.host.ID()[0:5], ready, expected)
+				return ready
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return ready
+		case <-pollTicker.C:
+		}
+	}
+}
@@ -12,7 +12,7 @@ import (
    dht "github.com/libp2p/go-libp2p-kad-dht"
 )
 
-func StartRegularSampling(blockID int, blockDimension int, parcelSize int, s *Service, ctx context.Context, stats *Stats, dht *dht.IpfsDHT, logger *log.Logger){
+func StartRegularSampling(blockID int, blockDimension int, parcelSize int, s *Service, ctx context.Context, stats *Stats, store SampleStore, dht *dht.IpfsDHT, logger *log.Logger){
 
    startTime := time.Now()
 
@@ -36,6 +36,14 @@ func StartRegularSampling(blockID int, blockDimension int, parcelSize int, s *Se
       blockID,
    )
 
+   if config.BatchGet {
+      s.BatchedSampling(ctx, store, dht, blockID, randomParcels, stats, false, "R")
+      logger.Println(formatJSONLogEvent(SamplingFinished, blockID))
+      stats.TotalSamplingLatencies = append(stats.TotalSamplingLatencies, time.Since(startTime))
+      log.Printf("[R - %s] Block %d sampling took %.2f seconds.\n", s.host.ID()[0:5], blockID, time.Since(startTime).Seconds())
+      return
+   }
+
    sampledParcelIDs := make([]int, 0)
    var parcelWg sync.WaitGroup
    for _, parcel := range randomParcels {
@@ -48,6 +56,7 @@ func StartRegularSampling(blockID int, blockDimension int, parcelSize int, s *Se
             parcelType = "row"
          }
 
+         subnetMisses := 0
          for !contains(sampledParcelIDs, p.StartingIndex) {
             //remainingTime := time.Until(startTime.Add(BLOCK_TIME_SEC * time.Second))
 
@@ -55,11 +64,66 @@ func StartRegularSampling(blockID int, blockDimension int, parcelSize int, s *Se
             //defer cancel()
 
             startTime := time.Now()
-               returnedPayload, err := dht.GetValue(
-                  ctx,
-                  "/das/sample/"+fmt.Sprint(blockID)+"/"+parcelType+"/"+fmt.Sprint(p.StartingIndex),
+
+            if s.directSamplingEnabled() {
+               ref := NewParcelRef(blockID, p)
+               returnedPayload, method, err := s.SampleDirect(ctx, dht, ref)
+               if err == nil {
+                  keyHash := sha256.Sum256([]byte(ref.Key()))
+
+                  stats.GetLatencies = append(stats.GetLatencies, time.Since(startTime))
+                  stats.GetHops = append(stats.GetHops, 0)
+                  stats.GetTimestamps = append(stats.GetTimestamps, time.Now())
+                  stats.BlockIDs = append(stats.BlockIDs, fmt.Sprint(blockID))
+                  stats.ParcelKeyHashes = append(stats.ParcelKeyHashes, fmt.Sprintf("%x", keyHash))
+                  stats.ParcelStatuses = append(stats.ParcelStatuses, "success")
+                  stats.Routes = append(stats.Routes, RouteInfo{Key: ref.Key()})
+                  stats.ParcelDataLengths = append(stats.ParcelDataLengths, len(returnedPayload))
+                  stats.GetMethods = append(stats.GetMethods, method)
+
+                  stats.TotalGetMessages += 1
+                  stats.TotalSuccessGets += 1
+
+                  sampledParcelIDs = append(sampledParcelIDs, p.StartingIndex)
+                  continue
+               }
+            }
+
+            if s.subnets != nil {
+               // The builder does not seed the store in subnet mode, so a subnet
+               // miss is counted as failed rather than retried on the store, and
+               // the parcel is given up on after subnetSampleAttempts misses.
+               ref := NewParcelRef(blockID, p)
+               keyHash := sha256.Sum256([]byte(ref.Key()))
+
+               stats.GetLatencies = append(stats.GetLatencies, time.Since(startTime))
+               stats.GetHops = append(stats.GetHops, 0)
+               stats.GetTimestamps = append(stats.GetTimestamps, time.Now())
+               stats.BlockIDs = append(stats.BlockIDs, fmt.Sprint(blockID))
+               stats.ParcelKeyHashes = append(stats.ParcelKeyHashes, fmt.Sprintf("%x", keyHash))
+               stats.ParcelStatuses = append(stats.ParcelStatuses, "fail")
+               stats.Routes = append(stats.Routes, RouteInfo{Key: ref.Key()})
+               stats.ParcelDataLengths = append(stats.ParcelDataLengths, 0)
+               stats.GetMethods = append(stats.GetMethods, "subnet")
+
+               stats.TotalFailedGets += 1
+               stats.TotalGetMessages += 1
+
+               subnetMisses++
+               if subnetMisses >= subnetSampleAttempts {
+                  break
+               }
+               time.Sleep(1000 * time.Millisecond)
+               continue
+            }
+
+               traceCtx, tracer := traceGet(ctx)
+               returnedPayload, err := store.Get(
+                  traceCtx,
+                  NewParcelRef(blockID, p),
                )
                getLatency := time.Since(startTime)
+               route := tracer.Finish(NewParcelRef(blockID, p).Key())
                getTimestamp := time.Now()
 
                keyHash := sha256.Sum256([]byte("/das/sample/" + fmt.Sprint(blockID) + "/" + parcelType + "/" + fmt.Sprint(p.StartingIndex)))
@@ -79,7 +143,9 @@ func StartRegularSampling(blockID int, blockDimension int, parcelSize int, s *Se
                   stats.BlockIDs = append(stats.BlockIDs, fmt.Sprint(blockID))
                   stats.ParcelKeyHashes = append(stats.ParcelKeyHashes, keyHashString)
                   stats.ParcelStatuses = append(stats.ParcelStatuses, parcelStatus)
+                  stats.Routes = append(stats.Routes, route)
                   stats.ParcelDataLengths = append(stats.ParcelDataLengths, len(returnedPayload))
+                  stats.GetMethods = append(stats.GetMethods, config.StoreType)
 
                   stats.TotalFailedGets += 1
                   stats.TotalGetMessages += 1
@@ -89,13 +155,17 @@ func StartRegularSampling(blockID int, blockDimension int, parcelSize int, s *Se
                   }
 
                } else {
+                  route = s.findHolder(ctx, NewParcelRef(blockID, p), route)
+
                   stats.GetLatencies = append(stats.GetLatencies, getLatency)
                   stats.GetHops = append(stats.GetHops, 0)
                   stats.GetTimestamps = append(stats.GetTimestamps, getTimestamp)
                   stats.BlockIDs = append(stats.BlockIDs, fmt.Sprint(blockID))
                   stats.ParcelKeyHashes = append(stats.ParcelKeyHashes, keyHashString)
                   stats.ParcelStatuses = append(stats.ParcelStatuses, "success")
+                  stats.Routes = append(stats.Routes, route)
                   stats.ParcelDataLengths = append(stats.ParcelDataLengths, len(returnedPayload))
+                  stats.GetMethods = append(stats.GetMethods, config.StoreType)
 
                   stats.TotalGetMessages += 1
                   stats.TotalSuccessGets += 1
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"log"
+
+	"github.com/ipfs/go-cid"
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	mh "github.com/multiformats/go-multihash"
+)
+
+// Number of providers of a parcel asked over the sample protocol.
+const providerSampleCount = 3
+
+// parcelCid is the CID a parcel is announced under in provider records.
+func parcelCid(key string) cid.Cid {
+	hash, err := mh.Sum([]byte(key), mh.SHA2_256, -1)
+	if err != nil {
+		panic(err)
+	}
+	return cid.NewCidV1(cid.Raw, hash)
+}
+
+// Reseed makes a validator a source of a parcel it just sampled, so that later
+// samplers do not all have to reach the peers the builder seeded. In put mode
+// the parcel is written back into the sample store; in provide mode it is kept
+// in the datastore and announced with a provider record.
+func (s *Service) Reseed(ctx context.Context, store SampleStore, dht *dht.IpfsDHT, ref ParcelRef, samples []byte, stats *Stats) {
+	var err error
+
+	switch config.ReseedMode {
+	case "put":
+		err = store.Put(ctx, ref, samples)
+	case "provide":
+		err = putLocalParcel(ctx, s.datastore, ref.Key(), samples)
+		if err == nil {
+			err = dht.Provide(ctx, parcelCid(ref.Key()), true)
+		}
+	default:
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err != nil {
+		stats.TotalFailedReseeds += 1
+		log.Printf("[V - %s] Failed to re-seed parcel %s: %s\n", s.host.ID()[0:5], ref.Key(), err.Error())
+		return
+	}
+	stats.TotalReseededParcels += 1
+}
+
+// SampleFromProviders looks up the provider records of a parcel and asks the
+// providers for it over the sample protocol.
+func (s *Service) SampleFromProviders(ctx context.Context, dht *dht.IpfsDHT, ref ParcelRef) ([]byte, error) {
+	findCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	for provider := range dht.FindProvidersAsync(findCtx, parcelCid(ref.Key()), providerSampleCount) {
+		if provider.ID == s.host.ID() {
+			continue
+		}
+
+		s.host.Peerstore().AddAddrs(provider.ID, provider.Addrs, sampleRequestTimeout)
+
+		requestCtx, cancel := context.WithTimeout(ctx, sampleRequestTimeout)
+		parcels, err := s.RequestParcels(requestCtx, provider.ID, []ParcelRef{ref})
+		cancel()
+
+		if err == nil && len(parcels) == 1 && parcels[0].Found {
+			publishHolders(ctx, provider.ID)
+			s.rememberHolders(ref.Key(), provider.ID)
+			return parcels[0].Samples, nil
+		}
+	}
+
+	return nil, errParcelNotFound
+}
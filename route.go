@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"strings"
+
+	kb "github.com/libp2p/go-libp2p-kbucket"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/routing"
+)
+
+// RouteInfo describes how one PUT or GET was routed: the record key, the
+// peers the record was sent to (PUT) or that answered the lookup (GET), and,
+// for a GET, the peers that returned the record.
+type RouteInfo struct {
+	Key     string
+	Peers   []peer.ID
+	Holders []peer.ID
+}
+
+type routeTracer struct {
+	cancel  context.CancelFunc
+	done    chan struct{}
+	peers   []peer.ID
+	holders []peer.ID
+}
+
+// traceRoute collects the query events of the DHT operation run with the
+// returned context. PutValue reports every peer it sends the record to as a
+// Value event, and so do the push and provider stores (see publishHolders).
+// GetValue reports every peer that answered as a PeerResponse event, without
+// saying which of them held the record; the push and provider stores report
+// the peer that returned it as a Value event.
+func traceRoute(ctx context.Context, eventType routing.QueryEventType) (context.Context, *routeTracer) {
+	traceCtx, cancel := context.WithCancel(ctx)
+	traceCtx, events := routing.RegisterForQueryEvents(traceCtx)
+
+	t := &routeTracer{cancel: cancel, done: make(chan struct{})}
+	go func() {
+		defer close(t.done)
+		for event := range events {
+			if event.Type == eventType && !containsPeer(t.peers, event.ID) {
+				t.peers = append(t.peers, event.ID)
+			}
+			if eventType != routing.Value && event.Type == routing.Value && !containsPeer(t.holders, event.ID) {
+				t.holders = append(t.holders, event.ID)
+			}
+		}
+	}()
+
+	return traceCtx, t
+}
+
+// tracePut traces the peers a PutValue sends the record to.
+func tracePut(ctx context.Context) (context.Context, *routeTracer) {
+	return traceRoute(ctx, routing.Value)
+}
+
+// traceGet traces the peers that answer a GetValue or provider lookup.
+func traceGet(ctx context.Context) (context.Context, *routeTracer) {
+	return traceRoute(ctx, routing.PeerResponse)
+}
+
+// Finish stops the trace and returns the peers seen for key.
+func (t *routeTracer) Finish(key string) RouteInfo {
+	t.cancel()
+	<-t.done
+	return RouteInfo{Key: key, Peers: t.peers, Holders: t.holders}
+}
+
+// publishHolders reports the peers a store put a record on, or the peer it
+// got the record from, as Value events, the way PutValue reports the peers it
+// stores on, so that the route trace of the caller records them.
+func publishHolders(ctx context.Context, peers ...peer.ID) {
+	for _, p := range peers {
+		routing.PublishQueryEvent(ctx, &routing.QueryEvent{Type: routing.Value, ID: p})
+	}
+}
+
+// findHolder fills in the holder of a record fetched with GetValue, which
+// kad-dht does not report. The lookup responders are probed over the sample
+// protocol, closest to the key first, until one holds the parcel in its
+// datastore. Routes that already name their holders are returned as is.
+func (s *Service) findHolder(ctx context.Context, ref ParcelRef, route RouteInfo) RouteInfo {
+	if !config.TraceHolders || len(route.Holders) > 0 {
+		return route
+	}
+
+	for _, p := range kb.SortClosestPeers(FilterSelf(route.Peers, s.host.ID()), kb.ConvertKey(ref.Key())) {
+		requestCtx, cancel := context.WithTimeout(ctx, sampleRequestTimeout)
+		parcels, err := s.ProbeParcels(requestCtx, p, []ParcelRef{ref})
+		cancel()
+
+		if err == nil && len(parcels) == 1 && parcels[0].Found {
+			route.Holders = []peer.ID{p}
+			s.rememberHolders(ref.Key(), p)
+			break
+		}
+	}
+	return route
+}
+
+// queryCounter counts the DHT queries sent by the lookups run with its
+// context.
+type queryCounter struct {
+	cancel  context.CancelFunc
+	done    chan struct{}
+	queries int
+}
+
+func countQueries(ctx context.Context) (context.Context, *queryCounter) {
+	countCtx, cancel := context.WithCancel(ctx)
+	countCtx, events := routing.RegisterForQueryEvents(countCtx)
+
+	c := &queryCounter{cancel: cancel, done: make(chan struct{})}
+	go func() {
+		defer close(c.done)
+		for event := range events {
+			if event.Type == routing.SendingQuery {
+				c.queries++
+			}
+		}
+	}()
+
+	return countCtx, c
+}
+
+// Finish stops counting and returns the number of queries sent.
+func (c *queryCounter) Finish() int {
+	c.cancel()
+	<-c.done
+	return c.queries
+}
+
+func containsPeer(peers []peer.ID, p peer.ID) bool {
+	for _, other := range peers {
+		if other == p {
+			return true
+		}
+	}
+	return false
+}
+
+// xorPrefix returns the first 64 bits of the XOR distance between a and b,
+// which is enough to order and plot Kademlia distances.
+func xorPrefix(a kb.ID, b kb.ID) string {
+	return fmt.Sprintf("%016x", binary.BigEndian.Uint64(a[0:8])^binary.BigEndian.Uint64(b[0:8]))
+}
+
+// columns formats the route for the operations CSV: the record key, the
+// peers, the holders, and for every peer its common prefix length and XOR
+// distance to the Kademlia ID of the key and to the requester.
+func (r RouteInfo) columns(requester peer.ID) []string {
+	if r.Key == "" {
+		return []string{"", "", "", "", "", "", ""}
+	}
+
+	keyID := kb.ConvertKey(r.Key)
+	requesterID := kb.ConvertPeerID(requester)
+
+	var peers, keyCPLs, keyDistances, requesterCPLs, requesterDistances []string
+	for _, p := range r.Peers {
+		peerID := kb.ConvertPeerID(p)
+		peers = append(peers, p.String())
+		keyCPLs = append(keyCPLs, fmt.Sprint(kb.CommonPrefixLen(peerID, keyID)))
+		keyDistances = append(keyDistances, xorPrefix(peerID, keyID))
+		requesterCPLs = append(requesterCPLs, fmt.Sprint(kb.CommonPrefixLen(peerID, requesterID)))
+		requesterDistances = append(requesterDistances, xorPrefix(peerID, requesterID))
+	}
+
+	var holders []string
+	for _, p := range r.Holders {
+		holders = append(holders, p.String())
+	}
+
+	return []string{
+		r.Key,
+		strings.Join(peers, " "),
+		strings.Join(holders, " "),
+		strings.Join(keyCPLs, " "),
+		strings.Join(keyDistances, " "),
+		strings.Join(requesterCPLs, " "),
+		strings.Join(requesterDistances, " "),
+	}
+}
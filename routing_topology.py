@@ -0,0 +1,156 @@
+import json
+import os
+import sys
+from datetime import datetime, timedelta
+import pandas as pd
+from rich.console import Console
+
+console = Console()
+
+DEFAULT_WINDOW_SEC = 30
+
+def parse_timestamp(value):
+    # Go writes RFC 3339 with nanoseconds, which fromisoformat does not take.
+    value = value.replace("Z", "+00:00")
+    if "." in value:
+        head, tail = value.split(".", 1)
+        digits = ""
+        while tail and tail[0].isdigit():
+            digits += tail[0]
+            tail = tail[1:]
+        value = f"{head}.{digits[:6].ljust(6, '0')}{tail}"
+    return datetime.fromisoformat(value)
+
+def load_snapshots(log_dir):
+    """
+    Reads every <peer>_routing_<nodeType>.jsonl file of an experiment.
+
+    Parameters:
+    log_dir (str): The directory the node logs were written to.
+
+    Returns:
+    list: The snapshots of all nodes, sorted by timestamp.
+    """
+    snapshots = []
+    for filename in os.listdir(log_dir):
+        if "_routing_" not in filename or not filename.endswith(".jsonl"):
+            continue
+        with open(os.path.join(log_dir, filename)) as f:
+            for line in f:
+                line = line.strip()
+                if line == "":
+                    continue
+                snapshot = json.loads(line)
+                snapshot["Timestamp"] = parse_timestamp(snapshot["Timestamp"])
+                snapshots.append(snapshot)
+
+    return sorted(snapshots, key=lambda s: s["Timestamp"])
+
+def latest_snapshots(snapshots, until):
+    """
+    Returns the last snapshot of every node taken at or before until.
+    """
+    latest = {}
+    for snapshot in snapshots:
+        if snapshot["Timestamp"] > until:
+            break
+        latest[snapshot["Peer"]] = snapshot
+    return latest
+
+def connected_components(nodes, edges):
+    parent = {node: node for node in nodes}
+
+    def find(node):
+        while parent[node] != node:
+            parent[node] = parent[parent[node]]
+            node = parent[node]
+        return node
+
+    for a, b in edges:
+        if a in parent and b in parent:
+            parent[find(a)] = find(b)
+
+    components = {}
+    for node in nodes:
+        components.setdefault(find(node), []).append(node)
+    return list(components.values())
+
+def get_topology_over_time(snapshots, window_sec=DEFAULT_WINDOW_SEC):
+    """
+    Rebuilds the network-wide routing table graph (an edge from every node to
+    every peer in its routing table) and connection graph at the end of every
+    window, from the latest snapshot of each node.
+
+    Parameters:
+    snapshots (list): The output of load_snapshots.
+    window_sec (int): The time between two reconstructions.
+
+    Returns:
+    DataFrame: One row per window.
+    """
+    if len(snapshots) == 0:
+        return pd.DataFrame()
+
+    start = snapshots[0]["Timestamp"]
+    end = snapshots[-1]["Timestamp"]
+
+    topology = []
+    elapsed = 0
+    while True:
+        until = start + timedelta(seconds=elapsed)
+        latest = latest_snapshots(snapshots, until)
+        nodes = list(latest.keys())
+
+        rt_edges = set()
+        connections = set()
+        rt_sizes = []
+        bucket_counts = []
+        for peer, snapshot in latest.items():
+            rt_sizes.append(len(snapshot["RoutingTable"]))
+            bucket_counts.append(len(set(p["Bucket"] for p in snapshot["RoutingTable"])))
+            for p in snapshot["RoutingTable"]:
+                rt_edges.add((peer, p["ID"]))
+            for p in snapshot["ConnectedPeers"]:
+                connections.add(tuple(sorted((peer, p))))
+
+        components = connected_components(nodes, rt_edges)
+        largest = max((len(c) for c in components), default=0)
+
+        topology.append({
+            "Elapsed (s)": elapsed,
+            "Nodes": len(nodes),
+            "DHT Servers": sum(1 for s in latest.values() if s["DHTServer"]),
+            "Mean RT Size": sum(rt_sizes) / len(rt_sizes) if rt_sizes else 0,
+            "Min RT Size": min(rt_sizes, default=0),
+            "Mean Non-empty Buckets": sum(bucket_counts) / len(bucket_counts) if bucket_counts else 0,
+            "RT Edges": len(rt_edges),
+            "Connections": len(connections),
+            "RT Components": len(components),
+            "Largest RT Component": largest / len(nodes) if nodes else 0,
+        })
+
+        if until >= end:
+            break
+        elapsed += window_sec
+
+    return pd.DataFrame(topology)
+
+if __name__ == "__main__":
+
+    if len(sys.argv) < 2:
+        console.print("Usage: python routing_topology.py <log_dir> [window_sec]")
+        sys.exit(1)
+
+    log_dir = sys.argv[1]
+    window_sec = int(sys.argv[2]) if len(sys.argv) > 2 else DEFAULT_WINDOW_SEC
+
+    snapshots = load_snapshots(log_dir)
+    if len(snapshots) == 0:
+        console.print(f"No routing table snapshots found in {log_dir}")
+        sys.exit(1)
+
+    topology = get_topology_over_time(snapshots, window_sec)
+    output_path = os.path.join(log_dir, "routing_topology.csv")
+    topology.to_csv(output_path, index=False)
+    console.print(topology)
+    console.print(f"Topology over time written to {output_path}")
@@ -1,22 +1,66 @@
 package main
 
-import "context"
+import (
+    "context"
+    "time"
+)
 
 const (
-    EchoService         = "EchoRPCAPI"
-    EchoServiceFuncEcho = "Echo"
+    CustodyService             = "CustodyRPCAPI"
+    CustodyServiceFuncPushParcel = "PushParcel"
+
+    ReadyService                 = "ReadyRPCAPI"
+    ReadyServiceFuncReportReady  = "ReportReady"
+    ReadyServiceFuncReadyNodes   = "ReadyNodes"
 )
 
-type EchoRPCAPI struct {
+type CustodyRPCAPI struct {
     service *Service
 }
 
-type Envelope struct {
+// ParcelEnvelope carries one parcel pushed by the builder to a custody node.
+type ParcelEnvelope struct {
+    Key     string
     Samples []byte
-    Message string
 }
 
-func (r *EchoRPCAPI) Echo(ctx context.Context, in Envelope, out *Envelope) error {
-    *out = r.service.ReceiveEcho(in)
+type PushReply struct {
+    Stored bool
+}
+
+func (r *CustodyRPCAPI) PushParcel(ctx context.Context, in ParcelEnvelope, out *PushReply) error {
+    reply, err := r.service.ReceiveParcel(ctx, in)
+    if err != nil {
+        return err
+    }
+    *out = reply
+    return nil
+}
+
+type ReadyRPCAPI struct {
+    service *Service
+}
+
+// ReadyReport is sent by a node to the coordinators once it is connected and
+// its routing table is bootstrapped.
+type ReadyReport struct {
+    Peer       string
+    NodeType   string
+    ReadyAt    time.Time
+    ReceivedAt time.Time
+}
+
+type ReadyReply struct {
+    Reports []ReadyReport
+}
+
+func (r *ReadyRPCAPI) ReportReady(ctx context.Context, in ReadyReport, out *ReadyReply) error {
+    in.ReceivedAt = time.Now()
+    r.service.recordReady(in)
+    return nil
+}
+
+func (r *ReadyRPCAPI) ReadyNodes(ctx context.Context, in struct{}, out *ReadyReply) error {
+    out.Reports = r.service.readyReports()
     return nil
 }
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+	"time"
+
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	kb "github.com/libp2p/go-libp2p-kbucket"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+)
+
+type RoutingTablePeer struct {
+	ID                            string    `json:"ID"`
+	Bucket                        int       `json:"Bucket"`
+	AddedAt                       time.Time `json:"AddedAt"`
+	LastUsefulAt                  time.Time `json:"LastUsefulAt"`
+	LastSuccessfulOutboundQueryAt time.Time `json:"LastSuccessfulOutboundQueryAt"`
+	Connected                     bool      `json:"Connected"`
+}
+
+// RoutingTableSnapshot is one line of the <peer>_routing_<nodeType>.jsonl
+// file. Bucket is the common prefix length of the peer with this node, i.e.
+// the Kademlia bucket the peer falls in.
+type RoutingTableSnapshot struct {
+	Timestamp      time.Time          `json:"Timestamp"`
+	Peer           string             `json:"Peer"`
+	NodeType       string             `json:"NodeType"`
+	DHTServer      bool               `json:"DHTServer"`
+	RoutingTable   []RoutingTablePeer `json:"RoutingTable"`
+	ConnectedPeers []string           `json:"ConnectedPeers"`
+}
+
+func takeRoutingTableSnapshot(h host.Host, kdht *dht.IpfsDHT, nodeType string) RoutingTableSnapshot {
+	self := kb.ConvertPeerID(h.ID())
+
+	snapshot := RoutingTableSnapshot{
+		Timestamp:      time.Now(),
+		Peer:           h.ID().String(),
+		NodeType:       nodeType,
+		DHTServer:      isDHTServer(kdht),
+		RoutingTable:   make([]RoutingTablePeer, 0),
+		ConnectedPeers: make([]string, 0),
+	}
+
+	for _, info := range kdht.RoutingTable().GetPeerInfos() {
+		snapshot.RoutingTable = append(snapshot.RoutingTable, RoutingTablePeer{
+			ID:                            info.Id.String(),
+			Bucket:                        kb.CommonPrefixLen(self, kb.ConvertPeerID(info.Id)),
+			AddedAt:                       info.AddedAt,
+			LastUsefulAt:                  info.LastUsefulAt,
+			LastSuccessfulOutboundQueryAt: info.LastSuccessfulOutboundQueryAt,
+			Connected:                     h.Network().Connectedness(info.Id) == network.Connected,
+		})
+	}
+
+	for _, p := range h.Network().Peers() {
+		snapshot.ConnectedPeers = append(snapshot.ConnectedPeers, p.String())
+	}
+
+	return snapshot
+}
+
+// StartRoutingTableSnapshots appends a snapshot of the routing table and of
+// the open connections to <peer>_routing_<nodeType>.jsonl every interval
+// seconds until ctx is done.
+func StartRoutingTableSnapshots(ctx context.Context, h host.Host, kdht *dht.IpfsDHT, nodeType string, interval int, nodeTypeSuffix string) {
+	filename := config.LogDirectory + h.ID().String()[0:10] + "_routing_" + nodeType + ".jsonl"
+	f, err := os.Create(filename)
+	if err != nil {
+		log.Printf("[%s - %s] Failed to create %s: %s\n", nodeTypeSuffix, h.ID()[0:5], filename, err.Error())
+		return
+	}
+	defer f.Close()
+
+	encoder := json.NewEncoder(f)
+
+	snapshotTicker := time.NewTicker(time.Duration(interval) * time.Second)
+	defer snapshotTicker.Stop()
+
+	for {
+		if err := encoder.Encode(takeRoutingTableSnapshot(h, kdht, nodeType)); err != nil {
+			log.Printf("[%s - %s] Failed to write routing table snapshot: %s\n", nodeTypeSuffix, h.ID()[0:5], err.Error())
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-snapshotTicker.C:
+		}
+	}
+}
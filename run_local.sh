@@ -7,6 +7,7 @@ non_validator_count=$4
 builder_ip=127.0.0.1
 parcel_size=$5
 exp_duration=$6
+netem_config=$7
 echo "Experiment name: $experiment_name"
 echo "Builder count: $builder_count"
 echo "Validator count: $validator_count"
@@ -14,6 +15,11 @@ echo "Non validator count: $non_validator_count"
 echo "Builder IP: $builder_ip"
 echo "Parcel size: $parcel_size"
 echo "Experiment duration: $exp_duration"
+echo "Netem config: $netem_config"
+netem_flag=""
+if [ -n "$netem_config" ]; then
+    netem_flag="-netem $netem_config"
+fi
 ip=127.0.0.1
 result_dir="./results"
 finish_time=$(date +%d-%m-%y-%H-%M)
@@ -27,12 +33,23 @@ if [ ! -d "$log_dir" ]; then
     mkdir -p "$log_dir"
 fi
 
+# The bootstrap node's peer ID is fixed by its seed.
+bootstrap_peer=/ip4/$builder_ip/tcp/61960/p2p/12D3KooWE3AwZFT9zEWDUxhya62hmvEbRxYBWaosn7Kiqw5wsu73
+
+# The builder produces blocks once every validator and regular node is ready.
+expected_nodes=$((validator_count + non_validator_count))
+
 port_counter=10200
+# Run the bootstrap node
+echo "[BACKGROUND] Running bootstrap node"
+go run . -seed 1234 -port 61960 -nodeType bootstrap -parcelSize $parcel_size -duration $exp_duration -ip $ip $netem_flag -log $log_dir/ >> $log_dir/${ip}_bootstrap.txt 2>&1 &
+sleep 1
+
 # Run builders
 for ((i=0; i<$builder_count-1; i++))
 do
     echo "[BACKGROUND] Running builder $i"
-    go run . -seed 1234 -port 61960 -nodeType builder -parcelSize $parcel_size -duration $exp_duration -ip $ip -log $log_dir/ >> $log_dir/${ip}_builder_$i.txt 2>&1 &
+    go run . -nodeType builder -peer $bootstrap_peer -expectedNodes $expected_nodes -parcelSize $parcel_size -duration $exp_duration -ip $ip $netem_flag -log $log_dir/ >> $log_dir/${ip}_builder_$i.txt 2>&1 &
     ((port_counter++))
     sleep 1
 done
@@ -40,11 +57,11 @@ if [ $(($builder_count)) -ne 0 ]; then
     if [ $(($non_validator_count)) -eq 0 ] && [ $(($validator_count)) -eq 0 ]; then
         echo "[FOREGROUND] Running builder [0]"
 
-        go run . -seed 1234 -port 61960 -nodeType builder -parcelSize $parcel_size -duration $exp_duration -ip $ip -log $log_dir/  >> $log_dir/${ip}_builder_$i.txt 2>&1
+        go run . -nodeType builder -peer $bootstrap_peer -expectedNodes $expected_nodes -parcelSize $parcel_size -duration $exp_duration -ip $ip $netem_flag -log $log_dir/  >> $log_dir/${ip}_builder_$i.txt 2>&1
         sleep 1
         ((port_counter++))
     else
-        go run . -seed 1234 -port 61960 -nodeType builder -parcelSize $parcel_size -duration $exp_duration -ip $ip -log $log_dir/  >> $log_dir/${ip}_builder_$i.txt 2>&1 &
+        go run . -nodeType builder -peer $bootstrap_peer -expectedNodes $expected_nodes -parcelSize $parcel_size -duration $exp_duration -ip $ip $netem_flag -log $log_dir/  >> $log_dir/${ip}_builder_$i.txt 2>&1 &
         sleep 1
         ((port_counter++))
     fi;
@@ -54,31 +71,31 @@ fi;
 for ((i=0; i<$validator_count - 1; i++))
 do
     echo "[BACKGROUND] Running validator $i"
-    go run . -nodeType validator -parcelSize $parcel_size -duration $exp_duration -ip $ip -peer /ip4/$builder_ip/tcp/61960/p2p/12D3KooWE3AwZFT9zEWDUxhya62hmvEbRxYBWaosn7Kiqw5wsu73  -log $log_dir/  >> $log_dir/${ip}_validator_$i.txt 2>&1 &
+    go run . -nodeType validator -parcelSize $parcel_size -duration $exp_duration -ip $ip -peer $bootstrap_peer  $netem_flag -log $log_dir/  >> $log_dir/${ip}_validator_$i.txt 2>&1 &
 done
 
 if [ $(($non_validator_count)) -eq 0 ]
 then
     if [ $(($validator_count)) -ne 0 ]; then
         echo "[FOREGROUND] Running validator $i"
-        go run . -nodeType validator -parcelSize $parcel_size -duration $exp_duration -ip $ip -peer /ip4/$builder_ip/tcp/61960/p2p/12D3KooWE3AwZFT9zEWDUxhya62hmvEbRxYBWaosn7Kiqw5wsu73  -log $log_dir/   >> $log_dir/${ip}_validator_$i.txt 2>&1
+        go run . -nodeType validator -parcelSize $parcel_size -duration $exp_duration -ip $ip -peer $bootstrap_peer  $netem_flag -log $log_dir/   >> $log_dir/${ip}_validator_$i.txt 2>&1
         sleep 1
     fi;
 else
     echo "[BACKGROUND] Running validator $i"
-    go run . -nodeType validator -parcelSize $parcel_size -duration $exp_duration -ip $ip -peer /ip4/$builder_ip/tcp/61960/p2p/12D3KooWE3AwZFT9zEWDUxhya62hmvEbRxYBWaosn7Kiqw5wsu73  -log $log_dir/   >> $log_dir/${ip}_validator_$i.txt 2>&1 &
+    go run . -nodeType validator -parcelSize $parcel_size -duration $exp_duration -ip $ip -peer $bootstrap_peer  $netem_flag -log $log_dir/   >> $log_dir/${ip}_validator_$i.txt 2>&1 &
 fi
 
 # Run non validators
 for ((i=0; i<$non_validator_count - 1; i++))
 do
     echo "[BACKGROUND] Running non validator $i"
-    go run . -nodeType nonvalidator -parcelSize $parcel_size -duration $exp_duration -ip $ip -peer /ip4/$builder_ip/tcp/61960/p2p/12D3KooWE3AwZFT9zEWDUxhya62hmvEbRxYBWaosn7Kiqw5wsu73  -log $log_dir/   >> $log_dir/${ip}_nonvalidator_$i.txt 2>&1 &
+    go run . -nodeType nonvalidator -parcelSize $parcel_size -duration $exp_duration -ip $ip -peer $bootstrap_peer  $netem_flag -log $log_dir/   >> $log_dir/${ip}_nonvalidator_$i.txt 2>&1 &
 done
 
 if [ $(($non_validator_count)) -ne 0 ]; then
     echo "[FOREGROUND] Running non validator $i"
-    go run . -nodeType nonvalidator -parcelSize $parcel_size -duration $exp_duration -ip $ip -peer /ip4/$builder_ip/tcp/61960/p2p/12D3KooWE3AwZFT9zEWDUxhya62hmvEbRxYBWaosn7Kiqw5wsu73  -log $log_dir/   >> $log_dir/${ip}_nonvalidator_$i.txt 2>&1
+    go run . -nodeType nonvalidator -parcelSize $parcel_size -duration $exp_duration -ip $ip -peer $bootstrap_peer  $netem_flag -log $log_dir/   >> $log_dir/${ip}_nonvalidator_$i.txt 2>&1
     sleep 1
 fi;
 
@@ -8,15 +8,18 @@ if [ -z "$nodeType" ] || [ -z "$parcelSize" ]; then
     exit 1
 fi
 
-if [ "$nodeType" != "builder" ] && [ "$nodeType" != "validator" ] && [ "$nodeType" != "nonvalidator" ]; then
-    echo "Invalid nodeType. Valid options are 'builder', 'validator', or 'nonvalidator'."
+if [ "$nodeType" != "bootstrap" ] && [ "$nodeType" != "builder" ] && [ "$nodeType" != "validator" ] && [ "$nodeType" != "nonvalidator" ]; then
+    echo "Invalid nodeType. Valid options are 'bootstrap', 'builder', 'validator', or 'nonvalidator'."
     exit 1
 fi
 
-if [ "$nodeType" == "builder" ]; then
-    go run . -seed 1234 -port 61960 -nodeType builder -parcelSize $parcelSize
+# The bootstrap node's peer ID is fixed by its seed.
+bootstrap_peer=/ip4/127.0.0.1/tcp/61960/p2p/12D3KooWE3AwZFT9zEWDUxhya62hmvEbRxYBWaosn7Kiqw5wsu73
+
+if [ "$nodeType" == "bootstrap" ]; then
+    go run . -seed 1234 -port 61960 -nodeType bootstrap -parcelSize $parcelSize
     exit 1
 else
-    go run . -nodeType $nodeType -peer /ip4/127.0.0.1/tcp/61960/p2p/12D3KooWE3AwZFT9zEWDUxhya62hmvEbRxYBWaosn7Kiqw5wsu73 -parcelSize $parcelSize
+    go run . -nodeType $nodeType -peer $bootstrap_peer -parcelSize $parcelSize
     exit 1
 fi
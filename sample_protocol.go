@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	kb "github.com/libp2p/go-libp2p-kbucket"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+)
+
+const SampleProtocolID = protocol.ID("/das/sample/1.0.0")
+
+// Number of routing table peers closest to a key that are asked directly
+// before falling back to a DHT lookup.
+const sampleHolderCount = 3
+
+const sampleRequestTimeout = 5 * time.Second
+
+var errParcelNotFound = errors.New("parcel not found on any holder")
+
+// ParcelRef identifies one parcel of a block.
+type ParcelRef struct {
+	BlockID       int
+	IsRow         bool
+	StartingIndex int
+}
+
+func NewParcelRef(blockID int, p Parcel) ParcelRef {
+	return ParcelRef{BlockID: blockID, IsRow: p.IsRow, StartingIndex: p.StartingIndex}
+}
+
+// Key is the DHT key the parcel is stored under.
+func (r ParcelRef) Key() string {
+	parcelType := "col"
+	if r.IsRow {
+		parcelType = "row"
+	}
+	return "/das/sample/" + fmt.Sprint(r.BlockID) + "/" + parcelType + "/" + fmt.Sprint(r.StartingIndex)
+}
+
+// SampleRequest asks for parcels. A probe only asks which of them the peer
+// holds in its datastore: the samples are not sent back, the parcel cache is
+// not consulted and nothing is counted as served.
+type SampleRequest struct {
+	Parcels []ParcelRef
+	Probe   bool
+}
+
+type SampledParcel struct {
+	ParcelRef
+	Found   bool
+	Samples []byte
+}
+
+type SampleResponse struct {
+	Parcels []SampledParcel
+}
+
+func (s *Service) SetupSampleProtocol() {
+	s.host.SetStreamHandler(SampleProtocolID, s.handleSampleStream)
+}
+
+// handleSampleStream answers a SampleRequest with the parcels this node holds
+// in its datastore or in its parcel cache.
+func (s *Service) handleSampleStream(stream network.Stream) {
+	defer stream.Close()
+
+	request := SampleRequest{}
+	if err := json.NewDecoder(stream).Decode(&request); err != nil {
+		log.Printf("Peer %s failed to read sample request: %s\n", s.host.ID()[0:5], err.Error())
+		stream.Reset()
+		return
+	}
+
+	response := SampleResponse{Parcels: make([]SampledParcel, 0, len(request.Parcels))}
+	servedCount := 0
+	for _, ref := range request.Parcels {
+		samples, err := getLocalParcel(context.Background(), s.datastore, ref.Key())
+		if request.Probe {
+			response.Parcels = append(response.Parcels, SampledParcel{ParcelRef: ref, Found: err == nil})
+			continue
+		}
+		if err != nil && s.cache != nil {
+			if cached, ok := s.cache.Get(ref.Key()); ok {
+				samples, err = cached, nil
+			}
+		}
+		response.Parcels = append(response.Parcels, SampledParcel{
+			ParcelRef: ref,
+			Found:     err == nil,
+			Samples:   samples,
+		})
+		if err == nil {
+			servedCount++
+		}
+	}
+
+	s.mu.Lock()
+	s.servedParcels += servedCount
+	s.mu.Unlock()
+
+	if err := json.NewEncoder(stream).Encode(&response); err != nil {
+		log.Printf("Peer %s failed to write sample response: %s\n", s.host.ID()[0:5], err.Error())
+		stream.Reset()
+	}
+}
+
+// RequestParcels asks p for the given parcels in a single round trip.
+func (s *Service) RequestParcels(ctx context.Context, p peer.ID, refs []ParcelRef) ([]SampledParcel, error) {
+	return s.sendSampleRequest(ctx, p, SampleRequest{Parcels: refs})
+}
+
+// ProbeParcels asks p which of the given parcels it holds in its datastore,
+// without fetching them.
+func (s *Service) ProbeParcels(ctx context.Context, p peer.ID, refs []ParcelRef) ([]SampledParcel, error) {
+	return s.sendSampleRequest(ctx, p, SampleRequest{Parcels: refs, Probe: true})
+}
+
+func (s *Service) sendSampleRequest(ctx context.Context, p peer.ID, request SampleRequest) ([]SampledParcel, error) {
+	stream, err := s.host.NewStream(ctx, p, SampleProtocolID)
+	if err != nil {
+		return nil, err
+	}
+	defer stream.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		stream.SetDeadline(deadline)
+	}
+
+	if err := json.NewEncoder(stream).Encode(&request); err != nil {
+		stream.Reset()
+		return nil, err
+	}
+	if err := stream.CloseWrite(); err != nil {
+		stream.Reset()
+		return nil, err
+	}
+
+	response := SampleResponse{}
+	if err := json.NewDecoder(stream).Decode(&response); err != nil {
+		stream.Reset()
+		return nil, err
+	}
+
+	return response.Parcels, nil
+}
+
+// rememberHolders records peers that returned or stored a parcel, most
+// recent first, so the fast path asks them before guessing from the routing
+// table. At most sampleHolderCount peers are kept per parcel.
+func (s *Service) rememberHolders(key string, peers ...peer.ID) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.holders == nil {
+		s.holders = make(map[string][]peer.ID)
+	}
+
+	holders := append([]peer.ID{}, peers...)
+	for _, p := range s.holders[key] {
+		if !containsPeer(holders, p) {
+			holders = append(holders, p)
+		}
+	}
+	if len(holders) > sampleHolderCount {
+		holders = holders[:sampleHolderCount]
+	}
+	s.holders[key] = holders
+}
+
+// forgetHolders drops the remembered holders of every block older than
+// oldestKept.
+func (s *Service) forgetHolders(oldestKept int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for key := range s.holders {
+		if blockID, ok := parcelKeyBlockID(key); ok && blockID < oldestKept {
+			delete(s.holders, key)
+		}
+	}
+}
+
+// knownHolders returns the peers that returned or stored the parcel before,
+// then the peers of the routing table closest to the parcel key, which are
+// the peers a DHT put would have stored it on.
+func (s *Service) knownHolders(dht *dht.IpfsDHT, ref ParcelRef) []peer.ID {
+	s.mu.Lock()
+	holders := append([]peer.ID{}, s.holders[ref.Key()]...)
+	s.mu.Unlock()
+
+	for _, p := range FilterSelf(dht.RoutingTable().NearestPeers(kb.ConvertKey(ref.Key()), sampleHolderCount), s.host.ID()) {
+		if !containsPeer(holders, p) {
+			holders = append(holders, p)
+		}
+	}
+	return holders
+}
+
+// SampleFromHolders is the sampling fast path: it asks the known holders of a
+// parcel directly over the sample protocol instead of running a DHT lookup.
+func (s *Service) SampleFromHolders(ctx context.Context, dht *dht.IpfsDHT, ref ParcelRef) ([]byte, peer.ID, error) {
+	for _, holder := range s.knownHolders(dht, ref) {
+		requestCtx, cancel := context.WithTimeout(ctx, sampleRequestTimeout)
+		parcels, err := s.RequestParcels(requestCtx, holder, []ParcelRef{ref})
+		cancel()
+
+		if err != nil {
+			continue
+		}
+		if len(parcels) == 1 && parcels[0].Found {
+			s.rememberHolders(ref.Key(), holder)
+			return parcels[0].Samples, holder, nil
+		}
+	}
+
+	return nil, "", errParcelNotFound
+}
+
+// directSamplingEnabled returns true if SampleDirect has any way to fetch a
+// parcel.
+func (s *Service) directSamplingEnabled() bool {
+	return s.subnets != nil || config.CustodyCount > 0 || config.ReseedMode == "provide" || config.SampleFastPath
+}
+
+// SampleDirect tries to fetch a parcel without a lookup in the sample store:
+// first from the peers of its subnet, then from its custodians, then from the
+// validators that announced it, then from its known holders. The returned
+// method says where the parcel came from.
+func (s *Service) SampleDirect(ctx context.Context, dht *dht.IpfsDHT, ref ParcelRef) ([]byte, string, error) {
+	samples, method, err := s.sampleDirect(ctx, dht, ref)
+	if err == nil && s.cache != nil {
+		s.cache.Add(ref.Key(), samples)
+	}
+	return samples, method, err
+}
+
+func (s *Service) sampleDirect(ctx context.Context, dht *dht.IpfsDHT, ref ParcelRef) ([]byte, string, error) {
+	if s.subnets != nil {
+		if samples, err := s.subnets.Sample(ctx, s, ref); err == nil {
+			return samples, "subnet", nil
+		}
+	}
+
+	if config.CustodyCount > 0 {
+		if samples, err := s.SampleFromCustodians(ctx, ref, s.rowCount, config.CustodyCount); err == nil {
+			return samples, "custody", nil
+		}
+	}
+
+	if config.ReseedMode == "provide" {
+		if samples, err := s.SampleFromProviders(ctx, dht, ref); err == nil {
+			return samples, "provider", nil
+		}
+	}
+
+	if config.SampleFastPath {
+		if samples, _, err := s.SampleFromHolders(ctx, dht, ref); err == nil {
+			return samples, "direct", nil
+		}
+	}
+
+	return nil, "", errParcelNotFound
+}
@@ -2,12 +2,13 @@ package main
 
 import (
 	"context"
-	"fmt"
 	"log"
 	"math/rand"
 	"sort"
+	"sync"
 	"time"
 
+	ds "github.com/ipfs/go-datastore"
 	rpc "github.com/libp2p/go-libp2p-gorpc"
 	dht "github.com/libp2p/go-libp2p-kad-dht"
 	"github.com/libp2p/go-libp2p/core/host"
@@ -21,6 +22,16 @@ type Service struct {
 	rpcClient *rpc.Client
 	host      host.Host
 	protocol  protocol.ID
+	datastore ds.Batching
+	subnets   *Subnets
+	cache     *parcelCache
+	rowCount  int
+
+	mu            sync.Mutex
+	servedParcels int
+	seededBlocks  map[int]time.Time
+	readyNodes    map[string]ReadyReport
+	holders       map[string][]peer.ID
 }
 
 type Parcel struct {
@@ -38,18 +49,24 @@ func contains(arr []int, val int) bool {
 	return false
 }
 
-func NewService(host host.Host, protocol protocol.ID) *Service {
+func NewService(host host.Host, protocol protocol.ID, datastore ds.Batching) *Service {
 	return &Service{
-		host:     host,
-		protocol: protocol,
+		host:      host,
+		protocol:  protocol,
+		datastore: datastore,
 	}
 }
 
 func (s *Service) SetupRPC() error {
-	echoRPCAPI := EchoRPCAPI{service: s}
+	custodyRPCAPI := CustodyRPCAPI{service: s}
+	readyRPCAPI := ReadyRPCAPI{service: s}
 
 	s.rpcServer = rpc.NewServer(s.host, s.protocol)
-	err := s.rpcServer.Register(&echoRPCAPI)
+	err := s.rpcServer.Register(&custodyRPCAPI)
+	if err != nil {
+		return err
+	}
+	err = s.rpcServer.Register(&readyRPCAPI)
 	if err != nil {
 		return err
 	}
@@ -163,12 +180,15 @@ func getParcelCounts(parcels []Parcel) (int, int) {
 	return rowParcelsCount, colParcelsCount
 }
 
-func (s *Service) ReceiveEcho(envelope Envelope) Envelope {
-	fmt.Printf("Peer %s got 42KB\n", s.host.ID())
-
-	return Envelope{
-		Message: fmt.Sprintf("Peer %s got 42KB", s.host.ID()),
+// ReceiveParcel stores a parcel pushed by the builder in the DHT datastore so
+// that it is served to GetValue like any other record.
+func (s *Service) ReceiveParcel(ctx context.Context, envelope ParcelEnvelope) (PushReply, error) {
+	if err := putLocalParcel(ctx, s.datastore, envelope.Key, envelope.Samples); err != nil {
+		log.Printf("Peer %s failed to store pushed parcel %s: %s\n", s.host.ID()[0:5], envelope.Key, err.Error())
+		return PushReply{Stored: false}, err
 	}
+
+	return PushReply{Stored: true}, nil
 }
 
 func FilterSelf(peers peer.IDSlice, self peer.ID) peer.IDSlice {
@@ -189,16 +209,16 @@ func Ctxts(n int) []context.Context {
 	return ctxs
 }
 
-func CopyEnvelopesToIfaces(in []*Envelope) []interface{} {
+func CopyPushRepliesToIfaces(in []*PushReply) []interface{} {
 	ifaces := make([]interface{}, len(in))
 	for i := range in {
-		in[i] = &Envelope{}
+		in[i] = &PushReply{}
 		ifaces[i] = in[i]
 	}
 	return ifaces
 }
 
-func (s *Service) StartMessaging(h host.Host, dht *dht.IpfsDHT, stats *Stats, peerType string, parcelSize int, ctx context.Context, exp_duration int, logger *log.Logger) {
+func (s *Service) StartMessaging(h host.Host, dht *dht.IpfsDHT, pub *Pub, store SampleStore, stats *Stats, peerType string, parcelSize int, ctx context.Context, exp_duration int, logger *log.Logger) {
 
 	if h == nil {
 		panic("Host is nil")
@@ -212,6 +232,9 @@ func (s *Service) StartMessaging(h host.Host, dht *dht.IpfsDHT, stats *Stats, pe
 	if ctx == nil {
 		panic("Context is nil")
 	}
+	if pub == nil {
+		panic("PubSub is nil")
+	}
 
 	const ROW_COUNT = 512 // ? ROW_COUNTxROW_COUNT matrix
 	const TOTAL_BLOCK_COUNT = 3
@@ -223,22 +246,46 @@ func (s *Service) StartMessaging(h host.Host, dht *dht.IpfsDHT, stats *Stats, pe
 		return
 	}
 
+	s.rowCount = ROW_COUNT
+
 	expeDurationTicker := time.NewTicker(time.Duration(exp_duration) * time.Second)
 	defer expeDurationTicker.Stop()
 	blockID := 0
 
-	pub, err := CreatePubSub(h, ctx)
-	if err != nil {
-		log.Println("Error creating pubSub:", err)
-		return
+	if config.SubnetCount > 0 {
+		s.subnets = NewSubnets(ctx, h, pub.ps, s.datastore, ROW_COUNT, config.SubnetCount)
+		if peerType != "builder" && peerType != "bootstrap" {
+			nodeTypeSuffix := "V"
+			if peerType == "nonvalidator" {
+				nodeTypeSuffix = "R"
+			}
+			if err := s.subnets.Subscribe(config.SubnetsPerNode, nodeTypeSuffix); err != nil {
+				log.Println("Error subscribing to subnets:", err)
+				return
+			}
+		}
 	}
 
 	if peerType == "builder" {
 
-		for len(dht.RoutingTable().ListPeers()) == 0 {
-			log.Printf("[B - %s] Waiting for peers to join...\n", s.host.ID()[0:5])
+		readyNodes := s.WaitForReady(ctx, dht, bootstrapPeerIDs(config.DiscoveryPeers), config.ExpectedNodes, config.ReadyFraction, time.Duration(config.ReadyTimeout)*time.Second)
+		stats.ProductionStartedAt = time.Now()
+		stats.ReadyNodesAtStart = readyNodes
+
+		if acceleratedFor(peerType) && config.StoreType == "dht" {
+			frt, err := NewAcceleratedClient(ctx, h, dht, s.datastore)
+			if err != nil {
+				log.Printf("[B - %s] Failed to start the accelerated DHT client, using the standard one: %s\n", s.host.ID()[0:5], err.Error())
+			} else {
+				defer frt.Close()
+				store = NewDHTStore(frt)
+				log.Printf("[B - %s] Accelerated DHT client ready with %d peers\n", s.host.ID()[0:5], len(frt.Stat()))
+			}
+		}
+
+		if config.AuditInterval > 0 {
+			go s.StartAudit(ctx, store, dht, ROW_COUNT, parcelSize, config.AuditInterval, config.AuditSampleCount, config.AuditWindow, stats)
 		}
-		time.Sleep(180*time.Second)
 
 		// TODO add exp_duration as a parameter
 		blockTicker := time.NewTicker(BLOCK_TIME_SEC * time.Second)
@@ -253,7 +300,15 @@ func (s *Service) StartMessaging(h host.Host, dht *dht.IpfsDHT, stats *Stats, pe
 			case <-blockTicker.C:
 				logger.Println(formatJSONLogEvent(HeaderSent, blockID))
 				pub.HeaderPublish(blockID, logger)
-				go StartSeedingBlock(blockID, ROW_COUNT, parcelSize, s, ctx, stats, dht)
+				s.blockSeeded(blockID)
+				if s.subnets != nil {
+					go s.subnets.PublishBlock(blockID, parcelSize, stats)
+				} else {
+					go StartSeedingBlock(blockID, ROW_COUNT, parcelSize, s, ctx, stats, store)
+				}
+				if config.RetentionSlots > 0 {
+					go s.PruneBlocks(ctx, blockID, config.RetentionSlots, stats, "B")
+				}
 				blockID += 1
 				//TODO add a mutex to make currBlock thread-safe
 			default:
@@ -272,7 +327,13 @@ func (s *Service) StartMessaging(h host.Host, dht *dht.IpfsDHT, stats *Stats, pe
 				//log.Printf("Got a message %s", msg)
 				logger.Println(formatJSONLogEvent(HeaderReceived, m.BlockID))
 				blockID = m.BlockID
-				go StartValidatorSampling(blockID, ROW_COUNT, parcelSize, s, ctx, stats, dht, logger)
+				go StartValidatorSampling(blockID, ROW_COUNT, parcelSize, s, ctx, stats, store, dht, logger)
+				if config.CustodyCount > 0 {
+					go s.AcquireCustody(ctx, store, dht, blockID, ROW_COUNT, parcelSize, config.CustodyCount, stats, "V")
+				}
+				if config.RetentionSlots > 0 {
+					go s.PruneBlocks(ctx, blockID, config.RetentionSlots, stats, "V")
+				}
 
 			default:
 			}
@@ -290,11 +351,30 @@ func (s *Service) StartMessaging(h host.Host, dht *dht.IpfsDHT, stats *Stats, pe
 				//log.Printf("Got a message %s", msg)
 				logger.Println(formatJSONLogEvent(HeaderReceived, m.BlockID))
 				blockID = m.BlockID
-				go StartRegularSampling(blockID, ROW_COUNT, parcelSize, s, ctx, stats, dht, logger)
+				go StartRegularSampling(blockID, ROW_COUNT, parcelSize, s, ctx, stats, store, dht, logger)
+				if config.CustodyCount > 0 {
+					go s.AcquireCustody(ctx, store, dht, blockID, ROW_COUNT, parcelSize, config.CustodyCount, stats, "R")
+				}
+				if config.RetentionSlots > 0 {
+					go s.PruneBlocks(ctx, blockID, config.RetentionSlots, stats, "R")
+				}
 			default:
 			}
 		}
 
+	} else if peerType == "bootstrap" {
+		// A bootstrap node neither seeds nor samples: it only answers DHT
+		// queries and relays headers until the experiment ends.
+		go pub.readLoop()
+		for {
+			select {
+			case <-expeDurationTicker.C:
+				log.Println("Experiment time exceeded")
+				return
+			case <-pub.messages:
+			}
+		}
+
 	} else {
 		panic("Peer type not recognized: " + peerType)
 	}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	ds "github.com/ipfs/go-datastore"
+	dsq "github.com/ipfs/go-datastore/query"
+)
+
+// StartStorageReport records, every interval seconds, how many /das/sample
+// records and bytes this node's datastore holds for each block, so the
+// spread of parcels over the DHT servers can be compared across nodes.
+func (s *Service) StartStorageReport(ctx context.Context, interval int, stats *Stats, nodeTypeSuffix string) {
+	reportTicker := time.NewTicker(time.Duration(interval) * time.Second)
+	defer reportTicker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-reportTicker.C:
+		}
+
+		if err := s.recordStorage(ctx, stats); err != nil {
+			log.Printf("[%s - %s] Failed to measure the datastore: %s\n", nodeTypeSuffix, s.host.ID()[0:5], err.Error())
+		}
+	}
+}
+
+func (s *Service) recordStorage(ctx context.Context, stats *Stats) error {
+	// Parcel records are stored under the base32 encoding of their DHT key, a
+	// single key segment, so no query prefix selects them: every key is
+	// listed, without its value, and filtered by parcelBlockID.
+	results, err := s.datastore.Query(ctx, dsq.Query{KeysOnly: true, ReturnsSizes: true})
+	if err != nil {
+		return err
+	}
+	defer results.Close()
+
+	blockRecords := make(map[int]int)
+	blockBytes := make(map[int]int64)
+	for result := range results.Next() {
+		if result.Error != nil {
+			return result.Error
+		}
+		blockID, ok := parcelBlockID(ds.NewKey(result.Key))
+		if !ok {
+			continue
+		}
+		blockRecords[blockID]++
+		blockBytes[blockID] += int64(result.Size)
+	}
+
+	reportTime := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for blockID, records := range blockRecords {
+		stats.StorageTimestamps = append(stats.StorageTimestamps, reportTime)
+		stats.StorageBlockIDs = append(stats.StorageBlockIDs, blockID)
+		stats.StorageRecords = append(stats.StorageRecords, records)
+		stats.StorageBytes = append(stats.StorageBytes, blockBytes[blockID])
+	}
+
+	return nil
+}
@@ -0,0 +1,117 @@
+import os
+import sys
+import pandas as pd
+from rich.console import Console
+
+console = Console()
+
+def get_storage_load(log_dir):
+    """
+    Merges the <peer>_storage_<nodeType>.csv files of an experiment and keeps,
+    for every node and block, the largest number of records and bytes the node
+    held for that block. Blocks a node never held show up with 0 records,
+    including every block of a node that never held any record.
+
+    Parameters:
+    log_dir (str): The directory the node logs were written to.
+
+    Returns:
+    DataFrame: One row per node and block.
+    """
+    storage_files = [f for f in os.listdir(log_dir) if "_storage_" in f and f.endswith(".csv")]
+    if len(storage_files) == 0:
+        console.print(f"No storage files found in {log_dir}")
+        return None
+
+    loads = []
+    peers = []
+    for storage_file in storage_files:
+        peer, node_type = storage_file[:-len(".csv")].split("_storage_")
+        peers.append({"Peer": peer, "Node Type": node_type})
+        df = pd.read_csv(os.path.join(log_dir, storage_file))
+        if len(df) == 0:
+            # Only blocks a node holds records for get rows, so a node that
+            # stored nothing has a header-only file. It is kept with 0 records.
+            continue
+        df = df.groupby("Block ID")[["Records", "Bytes"]].max().reset_index()
+        df["Peer"] = peer
+        df["Node Type"] = node_type
+        loads.append(df)
+
+    if len(loads) == 0:
+        console.print(f"No storage records found in {log_dir}")
+        return None
+
+    load = pd.concat(loads, ignore_index=True)
+
+    nodes = pd.DataFrame(peers).drop_duplicates()
+    blocks = load[["Block ID"]].drop_duplicates()
+    grid = nodes.merge(blocks, how="cross")
+    load = grid.merge(load, on=["Peer", "Node Type", "Block ID"], how="left").fillna({"Records": 0, "Bytes": 0})
+
+    return load
+
+def gini(values):
+    values = sorted(values)
+    n = len(values)
+    total = sum(values)
+    if n == 0 or total == 0:
+        return 0.0
+    weighted = sum((i + 1) * v for i, v in enumerate(values))
+    return (2 * weighted) / (n * total) - (n + 1) / n
+
+def get_storage_imbalance(load):
+    """
+    Summarises how evenly the records of every block are spread over the DHT
+    servers. The builder keeps a local copy of every record it puts, so it is
+    left out of the spread and its records are reported on their own.
+
+    Parameters:
+    load (DataFrame): The output of get_storage_load.
+
+    Returns:
+    DataFrame: One row per block.
+    """
+    imbalance = []
+    for block_id, block in load.groupby("Block ID"):
+        builder = block[block["Node Type"] == "builder"]
+        block = block[block["Node Type"] != "builder"]
+        records = block["Records"]
+        mean = records.mean() if len(records) > 0 else 0
+        max_records = int(records.max()) if len(records) > 0 else 0
+        imbalance.append({
+            "Block ID": block_id,
+            "Servers": len(block),
+            "Servers Holding Records": int((records > 0).sum()),
+            "Total Records": int(records.sum()),
+            "Total Bytes": int(block["Bytes"].sum()),
+            "Mean Records": mean,
+            "Std Records": records.std(ddof=0) if len(records) > 0 else 0,
+            "Max Records": max_records,
+            "Max / Mean": max_records / mean if mean > 0 else 0,
+            "Gini": gini(records.tolist()),
+            "Builder Records": int(builder["Records"].sum()),
+        })
+
+    return pd.DataFrame(imbalance)
+
+if __name__ == "__main__":
+
+    if len(sys.argv) < 2:
+        console.print("Usage: python storage_load.py <log_dir>")
+        sys.exit(1)
+
+    log_dir = sys.argv[1]
+    load = get_storage_load(log_dir)
+    if load is None:
+        sys.exit(1)
+
+    load_path = os.path.join(log_dir, "storage_load.csv")
+    load.to_csv(load_path, index=False)
+
+    imbalance = get_storage_imbalance(load)
+    imbalance_path = os.path.join(log_dir, "storage_imbalance.csv")
+    imbalance.to_csv(imbalance_path, index=False)
+
+    console.print(imbalance)
+    console.print(f"Storage load written to {load_path} and {imbalance_path}")
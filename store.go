@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	kb "github.com/libp2p/go-libp2p-kbucket"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+var (
+	errNoCustodyPeerStored = errors.New("no custody peer stored the parcel")
+	errMemoryStoreNotFound = errors.New("parcel not found in memory store")
+)
+
+// SampleStore is the overlay parcels are seeded into by the builder and
+// sampled from by validators and regular nodes.
+type SampleStore interface {
+	Put(ctx context.Context, ref ParcelRef, samples []byte) error
+	Get(ctx context.Context, ref ParcelRef) ([]byte, error)
+}
+
+// NewSampleStore returns the store selected with -store.
+func NewSampleStore(storeType string, s *Service, dht *dht.IpfsDHT) (SampleStore, error) {
+	switch storeType {
+	case "dht":
+		return NewDHTStore(dht), nil
+	case "push":
+		return &pushStore{service: s, dht: dht}, nil
+	case "provider":
+		return &providerStore{service: s, dht: dht}, nil
+	case "memory":
+		return NewMemoryStore(), nil
+	}
+	return nil, fmt.Errorf("sample store not recognized: %s", storeType)
+}
+
+// dhtStore keeps parcels as Kademlia value records, through the standard DHT
+// client or the accelerated one. A non-zero queryTimeout bounds every
+// PutValue and GetValue.
+type dhtStore struct {
+	dht          valueStore
+	queryTimeout time.Duration
+}
+
+func NewDHTStore(values valueStore) SampleStore {
+	return &dhtStore{dht: values, queryTimeout: time.Duration(config.DHTQueryTimeout) * time.Second}
+}
+
+func (d *dhtStore) queryContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if d.queryTimeout == 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, d.queryTimeout)
+}
+
+func (d *dhtStore) Put(ctx context.Context, ref ParcelRef, samples []byte) error {
+	ctx, cancel := d.queryContext(ctx)
+	defer cancel()
+	return d.dht.PutValue(ctx, ref.Key(), samples)
+}
+
+func (d *dhtStore) Get(ctx context.Context, ref ParcelRef) ([]byte, error) {
+	ctx, cancel := d.queryContext(ctx)
+	defer cancel()
+	return d.dht.GetValue(ctx, ref.Key())
+}
+
+// pushStore is the direct-push custody overlay: Put looks up the closest peers
+// of the key once and pushes the parcel to all of them over the custody RPC
+// service, Get asks the same peers over the sample protocol.
+type pushStore struct {
+	service *Service
+	dht     *dht.IpfsDHT
+}
+
+func (p *pushStore) Put(ctx context.Context, ref ParcelRef, samples []byte) error {
+	key := ref.Key()
+
+	if err := putLocalParcel(ctx, p.service.datastore, key, samples); err != nil {
+		return err
+	}
+
+	closestPeers, err := p.dht.GetClosestPeers(ctx, key)
+	if err != nil {
+		return err
+	}
+	closestPeers = FilterSelf(closestPeers, p.service.host.ID())
+
+	replies := make([]*PushReply, len(closestPeers))
+	errs := p.service.rpcClient.MultiCall(
+		Ctxts(len(closestPeers)),
+		closestPeers,
+		CustodyService,
+		CustodyServiceFuncPushParcel,
+		ParcelEnvelope{Key: key, Samples: samples},
+		CopyPushRepliesToIfaces(replies),
+	)
+
+	var stored []peer.ID
+	for i, callErr := range errs {
+		if callErr == nil && replies[i].Stored {
+			stored = append(stored, closestPeers[i])
+		}
+	}
+	if len(stored) == 0 {
+		return errNoCustodyPeerStored
+	}
+	publishHolders(ctx, stored...)
+	p.service.rememberHolders(key, stored...)
+	return nil
+}
+
+func (p *pushStore) Get(ctx context.Context, ref ParcelRef) ([]byte, error) {
+	closestPeers, err := p.dht.GetClosestPeers(ctx, ref.Key())
+	if err != nil {
+		return nil, err
+	}
+	closestPeers = kb.SortClosestPeers(FilterSelf(closestPeers, p.service.host.ID()), kb.ConvertKey(ref.Key()))
+
+	for _, holder := range closestPeers {
+		requestCtx, cancel := context.WithTimeout(ctx, sampleRequestTimeout)
+		parcels, err := p.service.RequestParcels(requestCtx, holder, []ParcelRef{ref})
+		cancel()
+
+		if err == nil && len(parcels) == 1 && parcels[0].Found {
+			publishHolders(ctx, holder)
+			p.service.rememberHolders(ref.Key(), holder)
+			return parcels[0].Samples, nil
+		}
+	}
+	return nil, errParcelNotFound
+}
+
+// providerStore keeps parcels in the datastore of the node that puts them and
+// announces them with provider records; Get looks up the providers of the
+// parcel and fetches it from them over the sample protocol.
+type providerStore struct {
+	service *Service
+	dht     *dht.IpfsDHT
+}
+
+func (p *providerStore) Put(ctx context.Context, ref ParcelRef, samples []byte) error {
+	if err := putLocalParcel(ctx, p.service.datastore, ref.Key(), samples); err != nil {
+		return err
+	}
+
+	// Provide sends the provider record to the closest peers its lookup
+	// reached without reporting them: they are the bucket size responders
+	// closest to the key of the provider record.
+	c := parcelCid(ref.Key())
+	provideCtx, tracer := traceGet(ctx)
+	err := p.dht.Provide(provideCtx, c, true)
+	responders := tracer.Finish(ref.Key()).Peers
+	if err != nil {
+		return err
+	}
+
+	stored := kb.SortClosestPeers(FilterSelf(responders, p.service.host.ID()), kb.ConvertKey(string(c.Hash())))
+	if len(stored) > config.DHTBucketSize {
+		stored = stored[:config.DHTBucketSize]
+	}
+	publishHolders(ctx, stored...)
+	return nil
+}
+
+func (p *providerStore) Get(ctx context.Context, ref ParcelRef) ([]byte, error) {
+	return p.service.SampleFromProviders(ctx, p.dht, ref)
+}
+
+// memoryStore keeps parcels in a map. It is only shared within one process, so
+// it is meant for tests and single-process runs.
+type memoryStore struct {
+	mu      sync.RWMutex
+	parcels map[string][]byte
+}
+
+func NewMemoryStore() *memoryStore {
+	return &memoryStore{parcels: make(map[string][]byte)}
+}
+
+func (m *memoryStore) Put(ctx context.Context, ref ParcelRef, samples []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.parcels[ref.Key()] = samples
+	return nil
+}
+
+func (m *memoryStore) Get(ctx context.Context, ref ParcelRef) ([]byte, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	samples, ok := m.parcels[ref.Key()]
+	if !ok {
+		return nil, errMemoryStoreNotFound
+	}
+	return samples, nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestMemoryStorePutGet(t *testing.T) {
+	store := NewMemoryStore()
+	ctx := context.Background()
+	ref := ParcelRef{BlockID: 3, IsRow: true, StartingIndex: 64}
+
+	if err := store.Put(ctx, ref, []byte("samples")); err != nil {
+		t.Fatalf("Put: %s", err)
+	}
+
+	samples, err := store.Get(ctx, ref)
+	if err != nil {
+		t.Fatalf("Get: %s", err)
+	}
+	if string(samples) != "samples" {
+		t.Fatalf("Get returned %q, want %q", samples, "samples")
+	}
+}
+
+func TestMemoryStoreNotFound(t *testing.T) {
+	store := NewMemoryStore()
+	ctx := context.Background()
+
+	if err := store.Put(ctx, ParcelRef{BlockID: 3, IsRow: true, StartingIndex: 64}, []byte("samples")); err != nil {
+		t.Fatalf("Put: %s", err)
+	}
+
+	// Same block and index, but the column parcel.
+	_, err := store.Get(ctx, ParcelRef{BlockID: 3, IsRow: false, StartingIndex: 64})
+	if !errors.Is(err, errMemoryStoreNotFound) {
+		t.Fatalf("Get of a missing parcel returned %v, want %v", err, errMemoryStoreNotFound)
+	}
+}
+
+func TestNewSampleStore(t *testing.T) {
+	tests := []struct {
+		storeType string
+		check     func(SampleStore) bool
+	}{
+		{"dht", func(s SampleStore) bool { _, ok := s.(*dhtStore); return ok }},
+		{"push", func(s SampleStore) bool { _, ok := s.(*pushStore); return ok }},
+		{"provider", func(s SampleStore) bool { _, ok := s.(*providerStore); return ok }},
+		{"memory", func(s SampleStore) bool { _, ok := s.(*memoryStore); return ok }},
+	}
+
+	for _, test := range tests {
+		store, err := NewSampleStore(test.storeType, &Service{}, nil)
+		if err != nil {
+			t.Fatalf("NewSampleStore(%q): %s", test.storeType, err)
+		}
+		if !test.check(store) {
+			t.Fatalf("NewSampleStore(%q) returned a %T", test.storeType, store)
+		}
+	}
+
+	if _, err := NewSampleStore("memroy", &Service{}, nil); err == nil {
+		t.Fatal("NewSampleStore accepted an unknown store type")
+	}
+}
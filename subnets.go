@@ -0,0 +1,260 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	ds "github.com/ipfs/go-datastore"
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// Number of subnet peers asked for a parcel before giving up on the subnet.
+const subnetSamplePeerCount = 3
+
+// Number of times a sampler asks the subnet for a parcel before counting it
+// as failed for good. The store is not seeded in subnet mode, so there is no
+// fallback.
+const subnetSampleAttempts = 5
+
+type SubnetParcelMessage struct {
+	BlockID       int    `json:"BlockID"`
+	IsRow         bool   `json:"IsRow"`
+	StartingIndex int    `json:"StartingIndex"`
+	Samples       []byte `json:"Samples"`
+}
+
+// Subnets disseminates row and column parcels over gossipsub, PeerDAS style.
+// Rows and columns are folded onto subnetCount row topics and subnetCount
+// column topics; every node subscribes to the subnets derived from its peer ID
+// and keeps the parcels it receives in its datastore to serve them.
+type Subnets struct {
+	host        host.Host
+	ctx         context.Context
+	ps          *pubsub.PubSub
+	datastore   ds.Batching
+	rowCount    int
+	subnetCount int
+
+	mu     sync.Mutex
+	topics map[string]*pubsub.Topic
+}
+
+func NewSubnets(ctx context.Context, h host.Host, ps *pubsub.PubSub, datastore ds.Batching, rowCount int, subnetCount int) *Subnets {
+	return &Subnets{
+		host:        h,
+		ctx:         ctx,
+		ps:          ps,
+		datastore:   datastore,
+		rowCount:    rowCount,
+		subnetCount: subnetCount,
+		topics:      make(map[string]*pubsub.Topic),
+	}
+}
+
+func subnetTopic(isRow bool, subnet int) string {
+	if isRow {
+		return fmt.Sprintf("das-row-subnet-%d", subnet)
+	}
+	return fmt.Sprintf("das-col-subnet-%d", subnet)
+}
+
+// parcelSubnet returns the subnet a parcel is published on: the row of a row
+// parcel or the column of a column parcel, folded onto subnetCount subnets.
+func (n *Subnets) parcelSubnet(ref ParcelRef) int {
+	if ref.IsRow {
+		return (ref.StartingIndex / n.rowCount) % n.subnetCount
+	}
+	return (ref.StartingIndex % n.rowCount) % n.subnetCount
+}
+
+// peerIndices deterministically picks count distinct indices in [0, n) for p,
+// so that any node can work out which subnets (or rows and columns) another
+// peer is responsible for. Different salts give independent picks.
+func peerIndices(p peer.ID, n int, count int, salt string) []int {
+	if count > n {
+		count = n
+	}
+
+	indices := make([]int, 0, count)
+	for i := 0; len(indices) < count; i++ {
+		hash := sha256.Sum256([]byte(fmt.Sprintf("%s/%s/%d", p, salt, i)))
+		index := int(binary.BigEndian.Uint64(hash[0:8]) % uint64(n))
+		if !contains(indices, index) {
+			indices = append(indices, index)
+		}
+	}
+	return indices
+}
+
+func (n *Subnets) topic(name string) (*pubsub.Topic, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if topic, ok := n.topics[name]; ok {
+		return topic, nil
+	}
+
+	topic, err := n.ps.Join(name)
+	if err != nil {
+		return nil, err
+	}
+	n.topics[name] = topic
+	return topic, nil
+}
+
+// Subscribe joins the row and column subnets of this node and stores every
+// parcel received on them until ctx is done.
+func (n *Subnets) Subscribe(perNode int, nodeTypeSuffix string) error {
+	rowSubnets := peerIndices(n.host.ID(), n.subnetCount, perNode, "row")
+	colSubnets := peerIndices(n.host.ID(), n.subnetCount, perNode, "col")
+
+	log.Printf("[%s - %s] Subscribing to row subnets %v and column subnets %v\n", nodeTypeSuffix, n.host.ID()[0:5], rowSubnets, colSubnets)
+
+	var names []string
+	for _, subnet := range rowSubnets {
+		names = append(names, subnetTopic(true, subnet))
+	}
+	for _, subnet := range colSubnets {
+		names = append(names, subnetTopic(false, subnet))
+	}
+
+	for _, name := range names {
+		topic, err := n.topic(name)
+		if err != nil {
+			return err
+		}
+		sub, err := topic.Subscribe()
+		if err != nil {
+			return err
+		}
+		go n.readLoop(sub)
+	}
+
+	return nil
+}
+
+func (n *Subnets) readLoop(sub *pubsub.Subscription) {
+	for {
+		msg, err := sub.Next(n.ctx)
+		if err != nil {
+			return
+		}
+		if msg.ReceivedFrom == n.host.ID() {
+			continue
+		}
+
+		m := new(SubnetParcelMessage)
+		if err := json.Unmarshal(msg.Data, m); err != nil {
+			continue
+		}
+
+		ref := ParcelRef{BlockID: m.BlockID, IsRow: m.IsRow, StartingIndex: m.StartingIndex}
+		if err := putLocalParcel(n.ctx, n.datastore, ref.Key(), m.Samples); err != nil {
+			log.Printf("Peer %s failed to store subnet parcel %s: %s\n", n.host.ID()[0:5], ref.Key(), err.Error())
+		}
+	}
+}
+
+// PublishBlock is the subnet counterpart of StartSeedingBlock: every row and
+// column parcel of the block is published on its subnet.
+func (n *Subnets) PublishBlock(blockID int, parcelSize int, stats *Stats) {
+
+	startTime := time.Now()
+	allParcels := SplitSamplesIntoParcels(n.rowCount, parcelSize, "all")
+
+	log.Printf("[B - %s] Publishing %d parcels for block %d on %d subnets...\n", n.host.ID()[0:5], len(allParcels), blockID, 2*n.subnetCount)
+
+	var parcelWg sync.WaitGroup
+	var statsMu sync.Mutex
+	for _, parcel := range allParcels {
+		parcelWg.Add(1)
+		go func(p Parcel) {
+			defer parcelWg.Done()
+
+			ref := NewParcelRef(blockID, p)
+			msgBytes, err := json.Marshal(&SubnetParcelMessage{
+				BlockID:       blockID,
+				IsRow:         p.IsRow,
+				StartingIndex: p.StartingIndex,
+				Samples:       make([]byte, p.SampleCount*512),
+			})
+			if err != nil {
+				log.Printf("[B - %s] Failed to encode parcel %d: %s\n", n.host.ID()[0:5], p.StartingIndex, err.Error())
+				return
+			}
+
+			publishStartTime := time.Now()
+			topic, err := n.topic(subnetTopic(p.IsRow, n.parcelSubnet(ref)))
+			if err == nil {
+				err = topic.Publish(n.ctx, msgBytes)
+			}
+			publishLatency := time.Since(publishStartTime)
+
+			keyHash := sha256.Sum256([]byte(ref.Key()))
+
+			statsMu.Lock()
+			defer statsMu.Unlock()
+
+			parcelStatus := "success"
+			if err != nil {
+				parcelStatus = "fail"
+				stats.TotalFailedPuts += 1
+				log.Printf("[B - %s] Failed to publish parcel %d: %s\n", n.host.ID()[0:5], p.StartingIndex, err.Error())
+			} else {
+				stats.TotalSuccessPuts += 1
+			}
+
+			stats.PutLatencies = append(stats.PutLatencies, publishLatency)
+			stats.PutTimestamps = append(stats.PutTimestamps, time.Now())
+			stats.BlockIDs = append(stats.BlockIDs, fmt.Sprint(blockID))
+			stats.ParcelKeyHashes = append(stats.ParcelKeyHashes, fmt.Sprintf("%x", keyHash))
+			stats.ParcelStatuses = append(stats.ParcelStatuses, parcelStatus)
+			stats.Routes = append(stats.Routes, RouteInfo{Key: ref.Key()})
+			stats.TotalPutMessages += 1
+		}(parcel)
+	}
+	parcelWg.Wait()
+
+	stats.SeedingLatencies = append(stats.SeedingLatencies, time.Since(startTime))
+
+	log.Printf("[B - %s] Finished publishing %d parcels.\n", n.host.ID()[0:5], len(allParcels))
+}
+
+// Sample fetches a parcel from this node's own subnets or from the peers
+// subscribed to the parcel's subnet.
+func (n *Subnets) Sample(ctx context.Context, s *Service, ref ParcelRef) ([]byte, error) {
+	if samples, err := getLocalParcel(ctx, n.datastore, ref.Key()); err == nil {
+		return samples, nil
+	}
+
+	for _, subnetPeer := range n.samplePeers(ref) {
+		requestCtx, cancel := context.WithTimeout(ctx, sampleRequestTimeout)
+		parcels, err := s.RequestParcels(requestCtx, subnetPeer, []ParcelRef{ref})
+		cancel()
+
+		if err == nil && len(parcels) == 1 && parcels[0].Found {
+			s.rememberHolders(ref.Key(), subnetPeer)
+			return parcels[0].Samples, nil
+		}
+	}
+
+	return nil, errParcelNotFound
+}
+
+// samplePeers returns the peers of the parcel's subnet that are asked for it,
+// at most subnetSamplePeerCount of them.
+func (n *Subnets) samplePeers(ref ParcelRef) []peer.ID {
+	subnetPeers := FilterSelf(n.ps.ListPeers(subnetTopic(ref.IsRow, n.parcelSubnet(ref))), n.host.ID())
+	if len(subnetPeers) > subnetSamplePeerCount {
+		subnetPeers = subnetPeers[:subnetSamplePeerCount]
+	}
+	return subnetPeers
+}
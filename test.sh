@@ -1,5 +1,6 @@
 #!/bin/bash
 
+rm -rf ./*_bootstrap.csv
 rm -rf ./*_builder.csv
 rm -rf ./*_validator.csv
 rm -rf ./*_nonvalidator.csv
@@ -35,6 +36,9 @@ declare -a bg_pids
 
 trap 'echo "Stopping all processes"; pkill -P $$; exit 1' SIGINT
 
+./run_node.sh bootstrap $parcelSize &
+bg_pids+=($!)
+
 for ((i=1; i<=$builderCount; i++)); do
     ./run_node.sh builder $parcelSize &
     bg_pids+=($!)  # Store the background process ID in the array
@@ -0,0 +1,152 @@
+import os
+import re
+import sys
+from datetime import timedelta
+from xml.sax.saxutils import escape
+from rich.console import Console
+
+from routing_topology import load_snapshots, latest_snapshots
+
+console = Console()
+
+ROLE_COLORS = {"bootstrap": "green", "builder": "red", "validator": "blue", "nonvalidator": "gray", "unknown": "black"}
+
+def get_log_roles(log_dir):
+    """
+    Reads node roles from the <nodeType>_<peer prefix>.log file names.
+
+    Returns:
+    dict: Peer ID prefix to the roles seen with that prefix. A prefix shared by
+    nodes of different roles maps to several roles and is not used.
+    """
+    roles = {}
+    for filename in os.listdir(log_dir):
+        match = re.match(r"^(bootstrap|builder|validator|nonvalidator)_(\w+)\.log$", filename)
+        if match:
+            roles.setdefault(match.group(2), set()).add(match.group(1))
+    return roles
+
+def peer_role(peer, snapshot_roles, log_roles):
+    if peer in snapshot_roles:
+        return snapshot_roles[peer]
+    for prefix, roles in log_roles.items():
+        if peer.startswith(prefix) and len(roles) == 1:
+            return next(iter(roles))
+    return "unknown"
+
+def build_graph(log_dir, elapsed_sec=None):
+    """
+    Merges the latest routing table snapshot of every node (at elapsed_sec
+    after the first snapshot, or at the end of the run) into one graph.
+
+    Returns:
+    tuple: (nodes, edges) where nodes maps a peer ID to its attributes and
+    edges maps a (from, to) pair to its attributes. A routing edge goes from a
+    node to a peer in its routing table; a connection edge is an open
+    connection seen by either side.
+    """
+    snapshots = load_snapshots(log_dir)
+    if len(snapshots) == 0:
+        return None, None
+
+    until = snapshots[-1]["Timestamp"]
+    if elapsed_sec is not None:
+        until = snapshots[0]["Timestamp"] + timedelta(seconds=elapsed_sec)
+    latest = latest_snapshots(snapshots, until)
+
+    log_roles = get_log_roles(log_dir)
+    snapshot_roles = {peer: snapshot["NodeType"] for peer, snapshot in latest.items()}
+
+    nodes = {}
+    edges = {}
+
+    def add_node(peer):
+        if peer not in nodes:
+            snapshot = latest.get(peer)
+            nodes[peer] = {
+                "role": peer_role(peer, snapshot_roles, log_roles),
+                "dht_server": snapshot["DHTServer"] if snapshot else False,
+                "rt_size": len(snapshot["RoutingTable"]) if snapshot else 0,
+                "reported": snapshot is not None,
+            }
+
+    def add_edge(a, b, kind):
+        add_node(a)
+        add_node(b)
+        edge = edges.setdefault((a, b), {"routing": False, "connected": False, "bucket": -1})
+        edge[kind] = True
+        return edge
+
+    for peer, snapshot in latest.items():
+        add_node(peer)
+        for p in snapshot["RoutingTable"]:
+            add_edge(peer, p["ID"], "routing")["bucket"] = p["Bucket"]
+
+    for peer, snapshot in latest.items():
+        for p in snapshot["ConnectedPeers"]:
+            routing_edges = [e for e in ((peer, p), (p, peer)) if e in edges]
+            if len(routing_edges) == 0:
+                add_edge(*sorted((peer, p)), "connected")
+            for e in routing_edges:
+                edges[e]["connected"] = True
+
+    return nodes, edges
+
+def write_graphml(nodes, edges, path):
+    with open(path, "w") as f:
+        f.write('<?xml version="1.0" encoding="UTF-8"?>\n')
+        f.write('<graphml xmlns="http://graphml.graphdrawing.org/xmlns">\n')
+        f.write('  <key id="role" for="node" attr.name="role" attr.type="string"/>\n')
+        f.write('  <key id="dht_server" for="node" attr.name="dht_server" attr.type="boolean"/>\n')
+        f.write('  <key id="rt_size" for="node" attr.name="rt_size" attr.type="int"/>\n')
+        f.write('  <key id="reported" for="node" attr.name="reported" attr.type="boolean"/>\n')
+        f.write('  <key id="routing" for="edge" attr.name="routing" attr.type="boolean"/>\n')
+        f.write('  <key id="connected" for="edge" attr.name="connected" attr.type="boolean"/>\n')
+        f.write('  <key id="bucket" for="edge" attr.name="bucket" attr.type="int"/>\n')
+        f.write('  <graph id="das" edgedefault="directed">\n')
+        for peer, attrs in nodes.items():
+            f.write(f'    <node id="{escape(peer)}">\n')
+            for key, value in attrs.items():
+                f.write(f'      <data key="{key}">{str(value).lower() if isinstance(value, bool) else escape(str(value))}</data>\n')
+            f.write('    </node>\n')
+        for (a, b), attrs in edges.items():
+            f.write(f'    <edge source="{escape(a)}" target="{escape(b)}">\n')
+            for key, value in attrs.items():
+                f.write(f'      <data key="{key}">{str(value).lower() if isinstance(value, bool) else value}</data>\n')
+            f.write('    </edge>\n')
+        f.write('  </graph>\n')
+        f.write('</graphml>\n')
+
+def write_dot(nodes, edges, path):
+    with open(path, "w") as f:
+        f.write("digraph das {\n")
+        for peer, attrs in nodes.items():
+            shape = "box" if attrs["dht_server"] else "ellipse"
+            f.write(f'  "{peer}" [label="{peer[-6:]}\\n{attrs["role"]}", color={ROLE_COLORS.get(attrs["role"], "black")}, shape={shape}];\n')
+        for (a, b), attrs in edges.items():
+            if attrs["routing"]:
+                style = "solid" if attrs["connected"] else "dotted"
+                f.write(f'  "{a}" -> "{b}" [style={style}];\n')
+            else:
+                f.write(f'  "{a}" -> "{b}" [style=dashed, dir=none];\n')
+        f.write("}\n")
+
+if __name__ == "__main__":
+
+    if len(sys.argv) < 2:
+        console.print("Usage: python topology_export.py <log_dir> [elapsed_sec]")
+        sys.exit(1)
+
+    log_dir = sys.argv[1]
+    elapsed_sec = int(sys.argv[2]) if len(sys.argv) > 2 else None
+
+    nodes, edges = build_graph(log_dir, elapsed_sec)
+    if nodes is None:
+        console.print(f"No routing table snapshots found in {log_dir}")
+        sys.exit(1)
+
+    graphml_path = os.path.join(log_dir, "topology.graphml")
+    dot_path = os.path.join(log_dir, "topology.dot")
+    write_graphml(nodes, edges, graphml_path)
+    write_dot(nodes, edges, dot_path)
+    console.print(f"{len(nodes)} nodes and {len(edges)} edges written to {graphml_path} and {dot_path}")
@@ -12,7 +12,7 @@ import (
 	dht "github.com/libp2p/go-libp2p-kad-dht"
 )
 
-func StartValidatorSampling(blockID int, blockDimension int, parcelSize int, s *Service, ctx context.Context, stats *Stats, dht *dht.IpfsDHT, logger *log.Logger) {
+func StartValidatorSampling(blockID int, blockDimension int, parcelSize int, s *Service, ctx context.Context, stats *Stats, store SampleStore, dht *dht.IpfsDHT, logger *log.Logger) {
 
 	startTime := time.Now()
 
@@ -56,6 +56,14 @@ func StartValidatorSampling(blockID int, blockDimension int, parcelSize int, s *
 		blockID,
 	)
 
+	if config.BatchGet {
+		s.BatchedSampling(ctx, store, dht, blockID, allRandomParcels, stats, config.ReseedMode != "off", "V")
+		logger.Println(formatJSONLogEvent(SamplingFinished, blockID))
+		stats.TotalSamplingLatencies = append(stats.TotalSamplingLatencies, time.Since(startTime))
+		log.Printf("[V - %s] Block %d sampling took %.2f seconds.\n", s.host.ID().String()[0:5], blockID, time.Since(startTime).Seconds())
+		return
+	}
+
 	sampledParcelIDs := make([]int, 0)
 	var parcelWg sync.WaitGroup
 	for _, parcel := range allRandomParcels {
@@ -69,13 +77,71 @@ func StartValidatorSampling(blockID int, blockDimension int, parcelSize int, s *
 			}
 
 			startTime := time.Now()
+			subnetMisses := 0
 			for !contains(sampledParcelIDs, p.StartingIndex) {
 
-				returnedPayload, err := dht.GetValue(
-					ctx,
-					"/das/sample/"+fmt.Sprint(blockID)+"/"+parcelType+"/"+fmt.Sprint(p.StartingIndex),
+				if s.directSamplingEnabled() {
+					ref := NewParcelRef(blockID, p)
+					returnedPayload, method, err := s.SampleDirect(ctx, dht, ref)
+					if err == nil {
+						keyHash := sha256.Sum256([]byte(ref.Key()))
+
+						stats.GetLatencies = append(stats.GetLatencies, time.Since(startTime))
+						stats.GetHops = append(stats.GetHops, 0)
+						stats.GetTimestamps = append(stats.GetTimestamps, time.Now())
+						stats.BlockIDs = append(stats.BlockIDs, fmt.Sprint(blockID))
+						stats.ParcelKeyHashes = append(stats.ParcelKeyHashes, fmt.Sprintf("%x", keyHash))
+						stats.ParcelStatuses = append(stats.ParcelStatuses, "success")
+						stats.Routes = append(stats.Routes, RouteInfo{Key: ref.Key()})
+						stats.ParcelDataLengths = append(stats.ParcelDataLengths, len(returnedPayload))
+						stats.GetMethods = append(stats.GetMethods, method)
+
+						stats.TotalGetMessages += 1
+						stats.TotalSuccessGets += 1
+
+						sampledParcelIDs = append(sampledParcelIDs, p.StartingIndex)
+						if config.ReseedMode != "off" {
+							go s.Reseed(ctx, store, dht, ref, returnedPayload, stats)
+						}
+						continue
+					}
+				}
+
+				if s.subnets != nil {
+					// The builder does not seed the store in subnet mode, so a subnet
+					// miss is counted as failed rather than retried on the store, and
+					// the parcel is given up on after subnetSampleAttempts misses.
+					ref := NewParcelRef(blockID, p)
+					keyHash := sha256.Sum256([]byte(ref.Key()))
+
+					stats.GetLatencies = append(stats.GetLatencies, time.Since(startTime))
+					stats.GetHops = append(stats.GetHops, 0)
+					stats.GetTimestamps = append(stats.GetTimestamps, time.Now())
+					stats.BlockIDs = append(stats.BlockIDs, fmt.Sprint(blockID))
+					stats.ParcelKeyHashes = append(stats.ParcelKeyHashes, fmt.Sprintf("%x", keyHash))
+					stats.ParcelStatuses = append(stats.ParcelStatuses, "fail")
+					stats.Routes = append(stats.Routes, RouteInfo{Key: ref.Key()})
+					stats.ParcelDataLengths = append(stats.ParcelDataLengths, 0)
+					stats.GetMethods = append(stats.GetMethods, "subnet")
+
+					stats.TotalFailedGets += 1
+					stats.TotalGetMessages += 1
+
+					subnetMisses++
+					if subnetMisses >= subnetSampleAttempts {
+						break
+					}
+					time.Sleep(1000 * time.Millisecond)
+					continue
+				}
+
+				traceCtx, tracer := traceGet(ctx)
+				returnedPayload, err := store.Get(
+					traceCtx,
+					NewParcelRef(blockID, p),
 				)
 				getLatency := time.Since(startTime)
+				route := tracer.Finish(NewParcelRef(blockID, p).Key())
 				getTimestamp := time.Now()
 
 				keyHash := sha256.Sum256([]byte("/das/sample/" + fmt.Sprint(blockID) + "/" + parcelType + "/" + fmt.Sprint(p.StartingIndex)))
@@ -95,25 +161,34 @@ func StartValidatorSampling(blockID int, blockDimension int, parcelSize int, s *
 					stats.BlockIDs = append(stats.BlockIDs, fmt.Sprint(blockID))
 					stats.ParcelKeyHashes = append(stats.ParcelKeyHashes, keyHashString)
 					stats.ParcelStatuses = append(stats.ParcelStatuses, parcelStatus)
+					stats.Routes = append(stats.Routes, route)
 					stats.ParcelDataLengths = append(stats.ParcelDataLengths, len(returnedPayload))
+					stats.GetMethods = append(stats.GetMethods, config.StoreType)
 
 					stats.TotalFailedGets += 1
 					stats.TotalGetMessages += 1
 					time.Sleep(1000 * time.Millisecond)
 
 				} else {
+					route = s.findHolder(ctx, NewParcelRef(blockID, p), route)
+
 					stats.GetLatencies = append(stats.GetLatencies, getLatency)
 					stats.GetHops = append(stats.GetHops, 0)
 					stats.GetTimestamps = append(stats.GetTimestamps, getTimestamp)
 					stats.BlockIDs = append(stats.BlockIDs, fmt.Sprint(blockID))
 					stats.ParcelKeyHashes = append(stats.ParcelKeyHashes, keyHashString)
 					stats.ParcelStatuses = append(stats.ParcelStatuses, "success")
+					stats.Routes = append(stats.Routes, route)
 					stats.ParcelDataLengths = append(stats.ParcelDataLengths, len(returnedPayload))
+					stats.GetMethods = append(stats.GetMethods, config.StoreType)
 
 					stats.TotalGetMessages += 1
 					stats.TotalSuccessGets += 1
 
 					sampledParcelIDs = append(sampledParcelIDs, p.StartingIndex)
+					if config.ReseedMode != "off" {
+						go s.Reseed(ctx, store, dht, NewParcelRef(blockID, p), returnedPayload, stats)
+					}
 				}
 			}
 